@@ -0,0 +1,94 @@
+package bloom
+
+import (
+	"hash/fnv"
+	"math"
+	"math/bits"
+	"sync/atomic"
+
+	"github.com/cold-bin/cb-cache/conv"
+)
+
+// Filter is a Bloom filter of string keys. Has never misses an added key,
+// but may report a key never added with the false positive rate.
+// Filter is safe for concurrent use, it can't forget keys, build a new one
+// and swap it instead.
+type Filter struct {
+	bits []uint64
+	m    uint64 // number of bits
+	k    uint64 // number of hash functions
+	n    uint64 // keys added, atomically
+}
+
+// New creates a filter sized for n keys at false positive rate p
+func New(n uint64, p float64) *Filter {
+	if n == 0 || p <= 0 || p >= 1 {
+		panic("illegal bloom filter size")
+	}
+
+	m := uint64(math.Ceil(-float64(n) * math.Log(p) / (math.Ln2 * math.Ln2)))
+	k := uint64(math.Max(1, math.Round(float64(m)/float64(n)*math.Ln2)))
+	words := (m + 63) / 64
+	return &Filter{bits: make([]uint64, words), m: words * 64, k: k}
+}
+
+// Add puts key into the filter
+func (f *Filter) Add(key string) {
+	h1, h2 := hash(key)
+	for i := uint64(0); i < f.k; i++ {
+		bit := (h1 + i*h2) % f.m
+		word, mask := &f.bits[bit/64], uint64(1)<<(bit%64)
+		for {
+			old := atomic.LoadUint64(word)
+			if old&mask != 0 || atomic.CompareAndSwapUint64(word, old, old|mask) {
+				break
+			}
+		}
+	}
+	atomic.AddUint64(&f.n, 1)
+}
+
+// Has reports whether key may have been added, false means it never was
+func (f *Filter) Has(key string) bool {
+	h1, h2 := hash(key)
+	for i := uint64(0); i < f.k; i++ {
+		bit := (h1 + i*h2) % f.m
+		if atomic.LoadUint64(&f.bits[bit/64])&(1<<(bit%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Len returns the number of keys added, counting duplicates
+func (f *Filter) Len() uint64 {
+	return atomic.LoadUint64(&f.n)
+}
+
+// Bits returns the size of the filter in bits
+func (f *Filter) Bits() uint64 {
+	return f.m
+}
+
+// Hashes returns the number of hash functions
+func (f *Filter) Hashes() uint64 {
+	return f.k
+}
+
+// FalsePositiveRate estimates the current false positive rate
+// from the fraction of bits set
+func (f *Filter) FalsePositiveRate() float64 {
+	var set int
+	for i := range f.bits {
+		set += bits.OnesCount64(atomic.LoadUint64(&f.bits[i]))
+	}
+	return math.Pow(float64(set)/float64(f.m), float64(f.k))
+}
+
+// hash derives the k hashes of key by double hashing
+func hash(key string) (h1, h2 uint64) {
+	a, b := fnv.New64a(), fnv.New64()
+	a.Write(conv.QuickS2B(key))
+	b.Write(conv.QuickS2B(key))
+	return a.Sum64(), b.Sum64() | 1 /*odd, to visit all bits*/
+}
@@ -0,0 +1,44 @@
+package bloom
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestFilter(t *testing.T) {
+	f := New(10000, 0.01)
+	for i := 0; i < 10000; i++ {
+		f.Add("key" + strconv.Itoa(i))
+	}
+	for i := 0; i < 10000; i++ {
+		if !f.Has("key" + strconv.Itoa(i)) {
+			t.Fatalf("key%d is missing", i)
+		}
+	}
+
+	var fp int
+	for i := 0; i < 10000; i++ {
+		if f.Has("absent" + strconv.Itoa(i)) {
+			fp++
+		}
+	}
+	if rate := float64(fp) / 10000; rate > 0.02 {
+		t.Errorf("false positive rate %.4f, want about 0.01", rate)
+	}
+	if est := f.FalsePositiveRate(); est < 0.005 || est > 0.02 {
+		t.Errorf("estimated false positive rate %.4f, want about 0.01", est)
+	}
+	if f.Len() != 10000 {
+		t.Errorf("Len = %d, want 10000", f.Len())
+	}
+}
+
+func TestFilter_Empty(t *testing.T) {
+	f := New(100, 0.01)
+	if f.Has("key") {
+		t.Error("empty filter has key")
+	}
+	if f.FalsePositiveRate() != 0 {
+		t.Errorf("empty filter false positive rate = %f", f.FalsePositiveRate())
+	}
+}
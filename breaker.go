@@ -0,0 +1,136 @@
+package cb_cache
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+var ErrBreakerOpen = errors.New("[cb-cache] circuit breaker is open")
+
+type BreakerState int32
+
+const (
+	BreakerClosed   BreakerState = iota // requests pass
+	BreakerOpen                         // requests fail fast
+	BreakerHalfOpen                     // a few trial requests pass
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	default:
+		return "unknown"
+	}
+}
+
+// breakerConfig of every peer, the breaker is disabled if threshold is 0
+type breakerConfig struct {
+	threshold   int           // consecutive failures opening the breaker
+	openTimeout time.Duration // time until the breaker becomes half-open
+	halfOpenMax int           // trial requests in half-open state
+}
+
+// circuitBreaker of one peer
+type circuitBreaker struct {
+	mu       sync.Mutex
+	state    BreakerState
+	failures int // consecutive failures
+	openedAt time.Time
+	trials   int // in-flight requests in half-open state
+}
+
+// allow reports whether a request may be sent now,
+// every allowed request must be followed by done
+func (b *circuitBreaker) allow(cfg *breakerConfig, now time.Time) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == BreakerOpen {
+		if now.Sub(b.openedAt) < cfg.openTimeout {
+			return ErrBreakerOpen
+		}
+		b.state, b.trials = BreakerHalfOpen, 0
+	}
+	if b.state == BreakerHalfOpen {
+		if b.trials >= cfg.halfOpenMax {
+			return ErrBreakerOpen
+		}
+		b.trials++
+	}
+	return nil
+}
+
+func (b *circuitBreaker) done(cfg *breakerConfig, now time.Time, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == BreakerHalfOpen {
+		b.trials--
+	}
+	if err == nil {
+		b.state, b.failures = BreakerClosed, 0
+		return
+	}
+
+	b.failures++
+	if b.state == BreakerHalfOpen || b.failures >= cfg.threshold {
+		b.state, b.openedAt = BreakerOpen, now
+	}
+}
+
+// cancel ends an allowed request the caller gave up on, it tells nothing
+// about the peer and leaves the state as it is
+func (b *circuitBreaker) cancel() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == BreakerHalfOpen {
+		b.trials--
+	}
+}
+
+func (b *circuitBreaker) State() BreakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
+
+// retryConfig of PeerGetter.Get, attempts include the first request
+type retryConfig struct {
+	attempts   int
+	backoff    time.Duration // base of the exponential backoff
+	maxBackoff time.Duration
+}
+
+// wait sleeps a full-jitter backoff before retry attempt+1
+func (cfg *retryConfig) wait(ctx context.Context, attempt int) error {
+	backoff := cfg.backoff << min(attempt, 16)
+	if cfg.maxBackoff > 0 && backoff > cfg.maxBackoff {
+		backoff = cfg.maxBackoff
+	}
+	if backoff <= 0 {
+		return ctx.Err()
+	}
+
+	timer := time.NewTimer(time.Duration(rand.Int63n(int64(backoff)) + 1))
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// retryable tells whether another attempt may succeed
+func retryable(ctx context.Context, err error) bool {
+	return ctx.Err() == nil && !errors.Is(err, ErrBreakerOpen) && !answered(err)
+}
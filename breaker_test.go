@@ -0,0 +1,131 @@
+package cb_cache
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/cold-bin/cb-cache/serialization"
+	"github.com/cold-bin/cb-cache/serialization/pb"
+)
+
+// countingServer fails the first failures requests and counts all of them
+func countingServer(t *testing.T, failures int32) (*httptest.Server, *int32) {
+	var hits int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&hits, 1) <= atomic.LoadInt32(&failures) {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		bs, _ := (&serialization.Protobuf{}).Marshal(&pb.Response{Value: []byte("ok")})
+		w.Write(bs)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &hits
+}
+
+func TestHTTPPool_CircuitBreaker(t *testing.T) {
+	srv, hits := countingServer(t, 3)
+	pool := NewHTTPPool("http://self", 50, WithCircuitBreaker(3, 50*time.Millisecond, 1))
+	pool.Set(srv.URL)
+
+	get := func() error {
+		peer, _ := pool.PickPeer("key")
+		_, err := peer.Get(context.Background(), &pb.Request{Group: "breaker", Key: "key"})
+		return err
+	}
+
+	for i := 0; i < 3; i++ {
+		if err := get(); err == nil || errors.Is(err, ErrBreakerOpen) {
+			t.Fatalf("request %d error = %v, want a peer error", i, err)
+		}
+	}
+	if err := get(); !errors.Is(err, ErrBreakerOpen) {
+		t.Errorf("Get error = %v, want %v", err, ErrBreakerOpen)
+	}
+	if got := atomic.LoadInt32(hits); got != 3 {
+		t.Errorf("peer got %d requests, want 3", got)
+	}
+	if state := pool.PeerStats()[srv.URL].Breaker; state != BreakerOpen {
+		t.Errorf("breaker is %v, want open", state)
+	}
+
+	// half-open after the timeout, the trial request succeeds and closes it
+	time.Sleep(60 * time.Millisecond)
+	if err := get(); err != nil {
+		t.Errorf("trial request error = %v", err)
+	}
+	if state := pool.PeerStats()[srv.URL].Breaker; state != BreakerClosed {
+		t.Errorf("breaker is %v, want closed", state)
+	}
+}
+
+func TestHTTPPool_Retry(t *testing.T) {
+	srv, hits := countingServer(t, 2)
+	pool := NewHTTPPool("http://self", 50, WithRetry(3, time.Millisecond, 10*time.Millisecond))
+	pool.Set(srv.URL)
+
+	peer, _ := pool.PickPeer("key")
+	res, err := peer.Get(context.Background(), &pb.Request{Group: "retry", Key: "key"})
+	if err != nil {
+		t.Fatalf("Get error = %v", err)
+	}
+	if string(res.GetValue()) != "ok" {
+		t.Errorf("Get = %s, want ok", res.GetValue())
+	}
+	if got := atomic.LoadInt32(hits); got != 3 {
+		t.Errorf("peer got %d requests, want 3", got)
+	}
+}
+
+func TestHTTPPool_BreakerCallerCancel(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(100 * time.Millisecond)
+		bs, _ := (&serialization.Protobuf{}).Marshal(&pb.Response{Value: []byte("ok")})
+		w.Write(bs)
+	}))
+	defer srv.Close()
+	pool := NewHTTPPool("http://self", 50, WithCircuitBreaker(3, time.Minute, 1))
+	pool.Set(srv.URL)
+	peer, _ := pool.PickPeer("key")
+
+	// callers giving up before the slow but healthy peer answers
+	for i := 0; i < 3; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Millisecond)
+		if _, err := peer.Get(ctx, &pb.Request{Group: "breaker-cancel", Key: "key"}); !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("Get error = %v, want the deadline", err)
+		}
+		cancel()
+	}
+	if state := pool.PeerStats()[srv.URL].Breaker; state != BreakerClosed {
+		t.Errorf("breaker is %v, want closed", state)
+	}
+	if _, err := peer.Get(context.Background(), &pb.Request{Group: "breaker-cancel", Key: "key"}); err != nil {
+		t.Errorf("Get error = %v", err)
+	}
+}
+
+func TestCircuitBreaker_CancelTrial(t *testing.T) {
+	cfg := &breakerConfig{threshold: 1, openTimeout: time.Millisecond, halfOpenMax: 1}
+	b := &circuitBreaker{}
+	now := time.Now()
+	b.allow(cfg, now)
+	b.done(cfg, now, errors.New("down"))
+
+	// the cancelled trial frees its place and keeps the breaker half-open
+	now = now.Add(2 * time.Millisecond)
+	if err := b.allow(cfg, now); err != nil {
+		t.Fatalf("trial error = %v", err)
+	}
+	b.cancel()
+	if state := b.State(); state != BreakerHalfOpen {
+		t.Errorf("breaker is %v, want half-open", state)
+	}
+	if err := b.allow(cfg, now); err != nil {
+		t.Errorf("next trial error = %v", err)
+	}
+}
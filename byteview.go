@@ -1,8 +1,28 @@
 package cb_cache
 
+import "time"
+
 // ByteView is an only read view, not allowed to write
 type ByteView struct {
 	b []byte
+	e time.Time     // expire time, zero if never
+	s bool          // expired, served while refreshing or the getter fails
+	d time.Duration // time it took to load, for early expiration
+}
+
+// Stale tells whether the view has expired, and is served because it is
+// being refreshed or the source failed
+func (v ByteView) Stale() bool {
+	return v.s
+}
+
+// Expire returns the time the view expires, zero if never
+func (v ByteView) Expire() time.Time {
+	return v.e
+}
+
+func (v ByteView) expired(now time.Time) bool {
+	return !v.e.IsZero() && !now.Before(v.e)
 }
 
 // Len returns the view's length
@@ -10,6 +10,7 @@ import (
 	"math/rand"
 	"sync"
 	"sync/atomic"
+	"time"
 )
 
 var (
@@ -25,6 +26,16 @@ type Stats struct {
 	GetterFuncFrom   uint64 // total good getter loads
 	GetterFuncFailed uint64 // total bad getter loads
 	ServerRequests   uint64 // gets that came over the network from peers
+	HedgesFired      uint64 // hedged requests sent because the peer was slow
+	HedgesWon        uint64 // hedged requests answering first
+	NegativeHits     uint64 // not-found answered from the negative cache
+	BloomRejects     uint64 // not-found answered by the bloom filter
+	StaleServes      uint64 // expired values served
+	RefreshAheads    uint64 // hot keys reloaded before they expired
+	RefreshDrops     uint64 // refresh-aheads dropped because the queue is full
+	GetterQueued     uint64 // getter calls waiting for the concurrency or rate limit
+	GetterRejected   uint64 // getter calls failed because the queue is full
+	GetterWait       uint64 // total nanoseconds getter calls waited in the queue
 
 	rlock sync.RWMutex
 }
@@ -37,6 +48,16 @@ type StatsCopy struct {
 	GetterFuncFrom   uint64 // total good getter loads
 	GetterFuncFailed uint64 // total bad getter loads
 	ServerRequests   uint64 // gets that came over the network from peers
+	HedgesFired      uint64 // hedged requests sent because the peer was slow
+	HedgesWon        uint64 // hedged requests answering first
+	NegativeHits     uint64 // not-found answered from the negative cache
+	BloomRejects     uint64 // not-found answered by the bloom filter
+	StaleServes      uint64 // expired values served
+	RefreshAheads    uint64 // hot keys reloaded before they expired
+	RefreshDrops     uint64 // refresh-aheads dropped because the queue is full
+	GetterQueued     uint64 // getter calls waiting for the concurrency or rate limit
+	GetterRejected   uint64 // getter calls failed because the queue is full
+	GetterWait       uint64 // total nanoseconds getter calls waited in the queue
 }
 
 // PrintEasyStatisticsInGroup
@@ -52,6 +73,16 @@ func (s *Stats) PrintEasyStatisticsInGroup() {
 		GetterFuncFrom:   s.GetterFuncFrom,
 		GetterFuncFailed: s.GetterFuncFailed,
 		ServerRequests:   s.ServerRequests,
+		HedgesFired:      s.HedgesFired,
+		HedgesWon:        s.HedgesWon,
+		NegativeHits:     s.NegativeHits,
+		BloomRejects:     s.BloomRejects,
+		StaleServes:      s.StaleServes,
+		RefreshAheads:    s.RefreshAheads,
+		RefreshDrops:     s.RefreshDrops,
+		GetterQueued:     s.GetterQueued,
+		GetterRejected:   s.GetterRejected,
+		GetterWait:       s.GetterWait,
 	}
 	s.rlock.RUnlock()
 	if state.Gets == 0 {
@@ -77,11 +108,32 @@ type Group struct {
 
 	mainCache cacheProxy // cached hot keys from local machine
 	hotCache  cacheProxy // cached hot keys from remote machine to avoid to request the same keys again
+	negCache  cacheProxy // keys known not to exist, to avoid cache penetration
+
+	nNegBytes int64         // max bytes of negCache
+	negTTL    time.Duration // how long a not-found is cached, 0 disables negative caching
+	keys      *keyFilter    // keys existing in the source, nil if not filtered
+
+	ttl                  time.Duration // expiration of cached values, 0 if never
+	staleWhileRevalidate time.Duration // serve expired values this long while refreshing them
+	staleIfError         time.Duration // serve expired values this long if the getter fails
+	revalidating         sync.Map      // keys refreshed in the background
+	refresh              *refreshAhead // nil if hot keys are not refreshed ahead
+	ttlJitter            float64       // fraction of ttl randomly added or subtracted
+	earlyBeta            float64       // XFetch beta of early expiration, 0 disables it
 
 	getter GetterFunc  // if got not in mainCache, use getter. this maybe prevent mainCache breakdown
 	peers  PeerPicker  // as a remote get-function from the other peers.
 	loader *safe.Group // make sure that every key is visited only once at the same time
 
+	limiter *getterLimiter // nil if the getter is not limited
+	chain   []*getterStage // stages of the getter, nil if it is not a chain
+	router  *getterRouter  // getters of key prefixes and patterns, nil if none
+
+	hedgeAfter      time.Duration // hedge peer requests slower than this
+	hedgePercentile float64       // or slower than this percentile of peer latencies
+	latencies       *latencyWindow
+
 	Stats Stats // statics data of every group
 }
 
@@ -110,6 +162,39 @@ func WithHotCacheBytes(cacheBytes int64) GOption {
 	}
 }
 
+// WithNegativeCache caches not-found results of the getter, i.e. ErrNotFound,
+// for ttl within their own budget of cacheBytes, so lookups of non-existent
+// keys don't hammer the source. Owners answer them to their peers as well.
+func WithNegativeCache(ttl time.Duration, cacheBytes int64) GOption {
+	return func(g *Group) {
+		if ttl <= 0 || cacheBytes <= 0 {
+			panic("negative cache ttl and bytes must be greater than 0")
+		}
+		g.negTTL = ttl
+		g.nNegBytes = cacheBytes
+	}
+}
+
+// WithHedging sends a peer request also to the next replica, or loads the key
+// locally if there is none, when the peer has not answered within delay.
+// The first success wins and the other request is cancelled.
+func WithHedging(delay time.Duration) GOption {
+	return func(g *Group) {
+		g.hedgeAfter = delay
+	}
+}
+
+// WithHedgingPercentile hedges the peer requests slower than percentile p
+// (0-100) of the recently observed peer latencies, e.g. 95. delay is used
+// until enough latencies have been observed.
+func WithHedgingPercentile(p float64, delay time.Duration) GOption {
+	return func(g *Group) {
+		g.hedgePercentile = p
+		g.hedgeAfter = delay
+		g.latencies = &latencyWindow{}
+	}
+}
+
 // NewGroup
 // nBytes: max bytes of group
 // maxitems: config of lru-k
@@ -125,17 +210,22 @@ func NewGroup(namespace string, nBytes int64, opts ...GOption) *Group {
 	g := &Group{
 		namespace: namespace,
 		nBytes:    nBytes,
-		mainCache: cacheProxy{cache: lruk.NewCache(2)},
-		hotCache:  cacheProxy{cache: lruk.NewCache(2)},
-		getter: func(ctx context.Context, k string) (v []byte, err error) {
-			return []byte{}, nil
-		}, /*default getter*/
+		// caches are created on first set, hooked to count evicted bytes
 		loader: &safe.Group{},
 	}
 
 	for _, opt := range opts {
 		opt(g)
 	}
+	if g.router != nil { /*the getter is the fallback of the routes*/
+		g.router.fallback = g.getter
+		g.getter = g.router.get
+	}
+	if g.getter == nil {
+		g.getter = func(ctx context.Context, k string) (v []byte, err error) {
+			return []byte{}, nil
+		} /*default getter*/
+	}
 	groups[namespace] = g
 
 	return g
@@ -177,50 +267,104 @@ func (g *Group) Get(ctx context.Context, k string) (ByteView, error) {
 	// first,try to get v from main cache and hot cache
 	value, cacheHit := g.localCache(k)
 	if cacheHit {
-		atomic.AddUint64(&g.Stats.CacheHits, 1)
+		now := time.Now()
+		switch {
+		case !value.expired(now) && !g.expiresEarly(now, value, rand.Float64()):
+			atomic.AddUint64(&g.Stats.CacheHits, 1)
+			g.refreshAhead(k, value, now)
+			return value, nil
+		case value.expired(now) && now.Before(value.e.Add(g.staleWhileRevalidate)):
+			atomic.AddUint64(&g.Stats.StaleServes, 1)
+			g.revalidate(ctx, k)
+			value.s = true
+			return value, nil
+		}
+	}
+	if g.negativeHit(k) {
+		atomic.AddUint64(&g.Stats.NegativeHits, 1)
+		return ByteView{}, ErrNotFound
+	}
+
+	// waiters give up on their own deadline, the load goes on while any waits
+	v, err, _ := g.loader.DoContext(ctx, k, func(ctx context.Context) (any, error) {
+		return g.load(ctx, k)
+	})
+	if err != nil && cacheHit && g.serveStale(value, err) {
+		if value.expired(time.Now()) {
+			atomic.AddUint64(&g.Stats.StaleServes, 1)
+			value.s = true
+		}
 		return value, nil
 	}
+	bv, _ := v.(ByteView)
+	return bv, err
+}
 
-	// second,try to get v from the remote peers
-	fn := func() (any, error) {
-		if g.peers != nil {
-			if peer, ok := g.peers.PickPeer(k); ok {
-				var (
-					err error
-					req = &pb.Request{Group: g.namespace, Key: k}
-					res = &pb.Response{}
-				)
-				if res, err = peer.Get(ctx, req); err == nil {
-					atomic.AddUint64(&g.Stats.PeerLoads, 1)
-					// should store the remote data from other peers in hotCache,
-					// but we can't store every key from remote. only P = 1/10
-					v := ByteView{b: res.Value}
-					if rand.Intn(10) == 0 {
-						g.populateCache(k, v, &g.hotCache)
-					}
-					return v, nil
+// load gets k from its owner, or the getter if the owner is self
+func (g *Group) load(ctx context.Context, k string) (ByteView, error) {
+	// second,try to get v from the remote peers,
+	// unless a peer has already routed the request here
+	if g.peers != nil && !routed(ctx) {
+		if peer, ok := g.peers.PickPeer(k); ok {
+			var (
+				err   error
+				req   = newPeerRequest(ctx, g.peers, g.namespace, k)
+				res   = &pb.Response{}
+				local bool
+			)
+			start := time.Now()
+			res, local, err = g.getFromPeer(ctx, k, peer, req)
+			if local {
+				// hedged to the getter, not a peer's value to cache
+				if err != nil {
+					return ByteView{}, err
 				}
-				atomic.AddUint64(&g.Stats.PeerErrors, 1)
+				return ByteView{b: res.Value, d: time.Since(start)}, nil
 			}
+			if err == nil {
+				atomic.AddUint64(&g.Stats.PeerLoads, 1)
+				// should store the remote data from other peers in hotCache,
+				// but we can't store every key from remote. only P = 1/10
+				v := ByteView{b: res.Value, s: res.GetStale(), d: time.Since(start)}
+				if !v.s && rand.Intn(10) == 0 {
+					g.populateCache(k, v, &g.hotCache)
+				}
+				return v, nil
+			} else if errors.Is(err, ErrNotFound) {
+				// the owner has asked the source already
+				atomic.AddUint64(&g.Stats.PeerLoads, 1)
+				g.populateNegative(k)
+				return ByteView{}, err
+			}
+			atomic.AddUint64(&g.Stats.PeerErrors, 1)
 		}
+	}
 
-		// not got in local cache, then got in g.Getter and store in mainCache locally
-		bs, err := g.getter(ctx, k)
-		if err != nil {
-			atomic.AddUint64(&g.Stats.GetterFuncFailed, 1)
-			return ByteView{}, err
+	// not got in local cache, then got in g.Getter and store in mainCache locally
+	if !g.mayExist(k) {
+		atomic.AddUint64(&g.Stats.BloomRejects, 1)
+		return ByteView{}, ErrNotFound
+	}
+	release, err := g.acquireGetter(ctx)
+	if err != nil {
+		return ByteView{}, err
+	}
+	start := time.Now()
+	bs, err := g.callGetter(ctx, k, release)
+	if err != nil {
+		atomic.AddUint64(&g.Stats.GetterFuncFailed, 1)
+		if errors.Is(err, ErrNotFound) {
+			g.populateNegative(k)
 		}
-		atomic.AddUint64(&g.Stats.GetterFuncFrom, 1)
-		bw := ByteView{b: cloneBytes(bs)}
-
-		// populate local cache
-		g.populateCache(k, bw, &g.mainCache)
-
-		return bw, nil
+		return ByteView{}, err
 	}
+	atomic.AddUint64(&g.Stats.GetterFuncFrom, 1)
+	bw := ByteView{b: cloneBytes(bs), d: time.Since(start)}
 
-	v, err := g.loader.Once(k, fn)
-	return v.(ByteView), err
+	// populate local cache
+	g.populateCache(k, bw, &g.mainCache)
+
+	return bw, nil
 }
 
 func (g *Group) localCache(k string) (value ByteView, ok bool) {
@@ -242,6 +386,9 @@ func (g *Group) populateCache(key string, value ByteView, cache *cacheProxy) {
 		return
 	}
 
+	if g.ttl > 0 {
+		value.e = g.expiration(time.Now(), rand.Float64())
+	}
 	cache.set(key, value)
 
 	// Evict items from cache(s) if necessary.
@@ -268,11 +415,32 @@ func (g *Group) populateCache(key string, value ByteView, cache *cacheProxy) {
 	}
 }
 
+// negativeHit tells whether k is cached as not found
+func (g *Group) negativeHit(k string) bool {
+	if g.negTTL <= 0 {
+		return false
+	}
+	v, ok := g.negCache.get(k)
+	return ok && !v.expired(time.Now())
+}
+
+func (g *Group) populateNegative(k string) {
+	if g.negTTL <= 0 {
+		return
+	}
+
+	g.negCache.set(k, ByteView{e: time.Now().Add(g.negTTL)})
+	for g.negCache.nBytes() > g.nNegBytes && g.negCache.nItems() > 0 {
+		g.negCache.removeOldest()
+	}
+}
+
 type CacheType uint8
 
 const (
 	MainCache = iota + 1
 	HotCache
+	NegativeCache
 )
 
 func (g *Group) CacheStates(cacheType CacheType) CacheStats {
@@ -281,6 +449,8 @@ func (g *Group) CacheStates(cacheType CacheType) CacheStats {
 		return g.mainCache.stats()
 	case HotCache:
 		return g.hotCache.stats()
+	case NegativeCache:
+		return g.negCache.stats()
 	default:
 		return CacheStats{}
 	}
@@ -301,9 +471,13 @@ func (c *cacheProxy) stats() CacheStats {
 	c.mu.RLock()
 	defer c.mu.RUnlock()
 
+	var items int64
+	if c.cache != nil {
+		items = int64(c.cache.Len())
+	}
 	return CacheStats{
 		Bytes:     c.nbytes,
-		Items:     int64(c.cache.Len()),
+		Items:     items,
 		Gets:      c.nget,
 		Hits:      c.nhit,
 		Evictions: c.nevict,
@@ -319,6 +493,9 @@ func (c *cacheProxy) nBytes() int64 {
 func (c *cacheProxy) nItems() int64 {
 	c.mu.RLock()
 	defer c.mu.RUnlock()
+	if c.cache == nil {
+		return 0
+	}
 	return int64(c.cache.Len())
 }
 
@@ -331,10 +508,26 @@ func (c *cacheProxy) set(key string, value ByteView) {
 			c.nevict++
 		}))
 	}
+	if p, ok := c.cache.(interface{ Peek(string) (any, bool) }); ok {
+		if old, ok := p.Peek(key); ok { /*overwrite*/
+			c.nbytes -= int64(len(key)) + int64(old.(ByteView).Len())
+		}
+	}
 	c.cache.Set(key, value)
 	c.nbytes += int64(len(key)) + int64(value.Len())
 }
 
+// visits of key counted by the cache, if it does
+func (c *cacheProxy) visits(key string) (n uint64, ok bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if v, ok := c.cache.(interface{ Visits(string) (uint64, bool) }); ok {
+		return v.Visits(key)
+	}
+	return
+}
+
 func (c *cacheProxy) get(key string) (value ByteView, ok bool) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
@@ -356,6 +549,9 @@ func (c *cacheProxy) removeOldest() {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
+	if c.cache == nil {
+		return
+	}
 	c.nevict--
 	c.cache.RemoveOldest()
 }
@@ -0,0 +1,150 @@
+package cb_cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// ErrorClass tells a getter chain what to do after a stage failed
+type ErrorClass int
+
+const (
+	ClassRetryable ErrorClass = iota // try the next stage
+	ClassNotFound                    // the key doesn't exist, stop with ErrNotFound
+	ClassFatal                       // stop with the error
+)
+
+// GetterStage is one source of a getter chain
+type GetterStage struct {
+	Name    string
+	Getter  GetterFunc
+	Timeout time.Duration // of one call, 0 if none
+	// Classify classifies the errors of Getter, DefaultClassify if nil
+	Classify func(err error) ErrorClass
+}
+
+// DefaultClassify stops the chain on ErrNotFound and ErrKeyEmpty,
+// and tries the next stage on any other error
+func DefaultClassify(err error) ErrorClass {
+	switch {
+	case errors.Is(err, ErrNotFound):
+		return ClassNotFound
+	case errors.Is(err, ErrKeyEmpty):
+		return ClassFatal
+	default:
+		return ClassRetryable
+	}
+}
+
+// GetterStageStats is the state of one stage of a getter chain
+type GetterStageStats struct {
+	Name      string
+	Calls     uint64
+	Successes uint64
+	NotFound  uint64
+	Failures  uint64 // including timeouts
+	Timeouts  uint64
+}
+
+type getterStage struct {
+	GetterStage
+	stats GetterStageStats // counters updated atomically
+}
+
+// WithGetterChain loads keys from stages in order, e.g. a read replica and
+// then the primary, instead of a single getter. A stage failing with a
+// retryable error or its timeout hands over to the next one.
+func WithGetterChain(stages ...GetterStage) GOption {
+	return func(g *Group) {
+		if len(stages) == 0 {
+			panic("getter chain without stages")
+		}
+		chain := make([]*getterStage, len(stages))
+		for i, s := range stages {
+			if s.Getter == nil {
+				panic("getter chain stage without getter")
+			}
+			if s.Classify == nil {
+				s.Classify = DefaultClassify
+			}
+			if s.Name == "" {
+				s.Name = fmt.Sprintf("stage-%d", i)
+			}
+			chain[i] = &getterStage{GetterStage: s, stats: GetterStageStats{Name: s.Name}}
+		}
+		g.chain = chain
+		g.getter = g.getChain
+	}
+}
+
+// getChain is the getter of a group with a getter chain
+func (g *Group) getChain(ctx context.Context, k string) ([]byte, error) {
+	var errs []error
+	for _, s := range g.chain {
+		v, err := s.get(ctx, k)
+		if err == nil {
+			return v, nil
+		}
+		if ctx.Err() != nil { /*the caller has gone*/
+			return nil, ctx.Err()
+		}
+
+		switch s.Classify(err) {
+		case ClassNotFound:
+			atomic.AddUint64(&s.stats.NotFound, 1)
+			if !errors.Is(err, ErrNotFound) {
+				err = fmt.Errorf("%w: %w", ErrNotFound, err)
+			}
+			return nil, err
+		case ClassFatal:
+			atomic.AddUint64(&s.stats.Failures, 1)
+			return nil, err
+		default:
+			atomic.AddUint64(&s.stats.Failures, 1)
+			errs = append(errs, fmt.Errorf("%s: %w", s.Name, err))
+		}
+	}
+	return nil, fmt.Errorf("[cb-cache] all getters failed: %w", errors.Join(errs...))
+}
+
+func (s *getterStage) get(ctx context.Context, k string) ([]byte, error) {
+	atomic.AddUint64(&s.stats.Calls, 1)
+	sctx := ctx
+	if s.Timeout > 0 {
+		var cancel context.CancelFunc
+		sctx, cancel = context.WithTimeout(ctx, s.Timeout)
+		defer cancel()
+	}
+
+	v, err := s.Getter(sctx, k)
+	if err == nil {
+		atomic.AddUint64(&s.stats.Successes, 1)
+		return v, nil
+	}
+	if ctx.Err() == nil && sctx.Err() != nil {
+		atomic.AddUint64(&s.stats.Timeouts, 1)
+	}
+	return nil, err
+}
+
+// GetterStats returns the state of every stage of the getter chain, nil without
+func (g *Group) GetterStats() []GetterStageStats {
+	if g.chain == nil {
+		return nil
+	}
+	stats := make([]GetterStageStats, 0, len(g.chain))
+	for _, s := range g.chain {
+		stats = append(stats, GetterStageStats{
+			Name:      s.Name,
+			Calls:     atomic.LoadUint64(&s.stats.Calls),
+			Successes: atomic.LoadUint64(&s.stats.Successes),
+			NotFound:  atomic.LoadUint64(&s.stats.NotFound),
+			Failures:  atomic.LoadUint64(&s.stats.Failures),
+			Timeouts:  atomic.LoadUint64(&s.stats.Timeouts),
+		})
+	}
+	return stats
+}
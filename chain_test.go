@@ -0,0 +1,104 @@
+package cb_cache
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestGroup_GetterChain(t *testing.T) {
+	errDown := errors.New("replica down")
+	errDenied := errors.New("access denied")
+	replica := func(ctx context.Context, k string) ([]byte, error) {
+		switch k {
+		case "slow":
+			<-ctx.Done()
+			return nil, ctx.Err()
+		case "down":
+			return nil, errDown
+		case "lagging", "missing":
+			return nil, ErrNotFound
+		case "denied":
+			return nil, errDenied
+		}
+		return []byte("replica"), nil
+	}
+	primary := func(ctx context.Context, k string) ([]byte, error) {
+		switch k {
+		case "missing":
+			return nil, ErrNotFound
+		case "down", "denied":
+			return nil, errors.New("primary down")
+		}
+		return []byte("primary"), nil
+	}
+
+	g := NewGroup("getter-chain", 1<<10, WithGetterChain(
+		GetterStage{Name: "replica", Getter: replica, Timeout: 20 * time.Millisecond,
+			Classify: func(err error) ErrorClass {
+				if errors.Is(err, errDenied) {
+					return ClassFatal
+				}
+				// the replica may lag behind
+				return ClassRetryable
+			}},
+		GetterStage{Name: "primary", Getter: primary},
+	))
+
+	testCases := []struct {
+		key  string
+		want string
+		err  error
+	}{
+		{"fast", "replica", nil},
+		{"slow", "primary", nil},
+		{"lagging", "primary", nil},
+		{"missing", "", ErrNotFound},
+		{"denied", "", errDenied},
+		{"down", "", errDown},
+	}
+	for _, tc := range testCases {
+		v, err := g.Get(context.Background(), tc.key)
+		if v.String() != tc.want || !errors.Is(err, tc.err) || (tc.err == nil) != (err == nil) {
+			t.Errorf("Get(%s) = %q %v, want %q %v", tc.key, v, err, tc.want, tc.err)
+		}
+	}
+
+	want := []GetterStageStats{
+		{Name: "replica", Calls: 6, Successes: 1, Failures: 5, Timeouts: 1},
+		{Name: "primary", Calls: 4, Successes: 2, NotFound: 1, Failures: 1},
+	}
+	for i, s := range g.GetterStats() {
+		if s != want[i] {
+			t.Errorf("stage %d stats = %+v, want %+v", i, s, want[i])
+		}
+	}
+}
+
+func TestGroup_GetterChainCallerGone(t *testing.T) {
+	var primaryCalls int
+	g := NewGroup("getter-chain-caller", 1<<10, WithGetterChain(
+		GetterStage{Getter: func(ctx context.Context, k string) ([]byte, error) {
+			<-ctx.Done()
+			return nil, ctx.Err()
+		}},
+		GetterStage{Getter: func(ctx context.Context, k string) ([]byte, error) {
+			primaryCalls++
+			return []byte("v"), nil
+		}},
+	))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := g.Get(ctx, "k"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Get error = %v, want deadline exceeded", err)
+	}
+	time.Sleep(10 * time.Millisecond)
+	if primaryCalls != 0 {
+		t.Error("next stage called after the caller has gone")
+	}
+	if s := g.GetterStats()[0]; s.Name != "stage-0" || s.Timeouts != 0 {
+		t.Errorf("stage stats = %+v, the caller's deadline is no timeout", s)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"hash/crc32"
 	"sort"
 	"strconv"
+	"strings"
 )
 
 type Hash func([]byte) uint32
@@ -14,10 +15,37 @@ type Map struct {
 	replica int            // number of per real node's virtual node
 	keys    []int          // sorted hash ring
 	hashMap map[int]string // a map from virtual node to real node
+	weights map[string]int // of the real nodes set with a weight other than 1
+	// only hash the {tag} of a key if it has one, so related keys share a node
+	hashTags bool
 }
 
 type MOpt func(*Map)
 
+// WithHashTags enables Redis-style hash tags: if a key contains a non-empty
+// {...} substring, only the substring is hashed, e.g. "{user:1}:name" and
+// "{user:1}:age" are always placed on the same node.
+func WithHashTags() MOpt {
+	return func(m *Map) {
+		m.hashTags = true
+	}
+}
+
+// HashTag returns the part of key that is hashed when hash tags are enabled:
+// the content between the first '{' and the following '}' if not empty,
+// otherwise the whole key.
+func HashTag(key string) string {
+	start := strings.IndexByte(key, '{')
+	if start < 0 {
+		return key
+	}
+	end := strings.IndexByte(key[start+1:], '}')
+	if end <= 0 /*no '}' or empty tag*/ {
+		return key
+	}
+	return key[start+1 : start+1+end]
+}
+
 func WithHash(hash Hash) MOpt {
 	return func(m *Map) {
 		m.hash = hash
@@ -45,6 +73,28 @@ func NewMap(replica int, opts ...MOpt) *Map {
 
 // Set adds some keys into hash
 func (m *Map) Set(keys ...string) {
+	m.SetWeight(1, keys...)
+}
+
+// SetWeight adds some keys with weight times the virtual nodes of a key
+// set by Set, so they get about weight times the share of the keys
+func (m *Map) SetWeight(weight int, keys ...string) {
+	if weight <= 0 {
+		panic("illegal weight")
+	}
+	for _, key := range keys {
+		// the first virtual nodes are the same for every weight
+		if old, ok := m.weights[key]; ok && old > weight {
+			m.Remove(key)
+		}
+		if weight == 1 {
+			continue
+		}
+		if m.weights == nil {
+			m.weights = make(map[string]int)
+		}
+		m.weights[key] = weight
+	}
 	m.resetKeys(func(key string, hash int) {
 		m.hashMap[hash] = key
 	}, keys)
@@ -52,14 +102,23 @@ func (m *Map) Set(keys ...string) {
 
 func (m *Map) Remove(keys ...string) {
 	m.resetKeys(func(key string, hash int) {
-		delete(m.hashMap, hash)
+		if m.hashMap[hash] == key {
+			delete(m.hashMap, hash)
+		}
 	}, keys)
+	for _, key := range keys {
+		delete(m.weights, key)
+	}
 }
 
 // 重置keys
 func (m *Map) resetKeys(fn func(key string, hash int), keys []string) {
 	for _, key := range keys {
-		for i := 0; i < m.replica; i++ {
+		weight, ok := m.weights[key]
+		if !ok {
+			weight = 1
+		}
+		for i := 0; i < m.replica*weight; i++ {
 			hash := int(m.hash(conv.QuickS2B(strconv.Itoa(i) + key)))
 			fn(key, hash)
 		}
@@ -79,8 +138,37 @@ func (m *Map) Get(key string) string {
 		return ""
 	}
 
-	hash := int(m.hash(conv.QuickS2B(key)))
-	idx := sort.Search(len(m.keys), func(i int) bool { return m.keys[i] >= hash })
+	idx := m.search(key)
 	// if idx==len(m.Keys), return the first key in the cycle
 	return m.hashMap[m.keys[idx%len(m.keys)]]
 }
+
+// Walk visits the distinct real nodes clockwise from the position of key,
+// starting with the owner returned by Get, until fn returns false.
+func (m *Map) Walk(key string, fn func(node string) bool) {
+	if len(m.keys) == 0 {
+		return
+	}
+
+	idx := m.search(key)
+	seen := make(map[string]struct{})
+	for i := 0; i < len(m.keys); i++ {
+		node := m.hashMap[m.keys[(idx+i)%len(m.keys)]]
+		if _, ok := seen[node]; ok {
+			continue
+		}
+		seen[node] = struct{}{}
+		if !fn(node) {
+			return
+		}
+	}
+}
+
+// search returns the index of the first virtual node at or after key on the ring
+func (m *Map) search(key string) int {
+	if m.hashTags {
+		key = HashTag(key)
+	}
+	hash := int(m.hash(conv.QuickS2B(key)))
+	return sort.Search(len(m.keys), func(i int) bool { return m.keys[i] >= hash })
+}
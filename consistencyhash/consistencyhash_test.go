@@ -1,6 +1,7 @@
 package consistencyhash
 
 import (
+	"reflect"
 	"strconv"
 	"testing"
 )
@@ -37,3 +38,106 @@ func TestConsistencyHash(t *testing.T) {
 		}
 	}
 }
+
+func TestWalk(t *testing.T) {
+	hash := NewMap(3, WithHash(func(key []byte) uint32 {
+		i, _ := strconv.Atoi(string(key))
+		return uint32(i)
+	}))
+	// 2, 4, 6, 12, 14, 16, 22, 24, 26
+	hash.Set("6", "4", "2")
+
+	var got []string
+	hash.Walk("11", func(node string) bool {
+		got = append(got, node)
+		return true
+	})
+	if want := []string{"2", "4", "6"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Walk(11) = %v, want %v", got, want)
+	}
+
+	got = got[:0]
+	hash.Walk("25", func(node string) bool {
+		got = append(got, node)
+		return len(got) < 2
+	})
+	if want := []string{"6", "2"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Walk(25) = %v, want %v", got, want)
+	}
+}
+
+func TestHashTag(t *testing.T) {
+	testCases := map[string]string{
+		"{user:1}:name": "user:1",
+		"profile:{42}":  "42",
+		"{}:empty":      "{}:empty",
+		"{a}{b}":        "a",
+		"no-tag":        "no-tag",
+		"open{only":     "open{only",
+		"}{x}":          "x",
+	}
+	for k, v := range testCases {
+		if got := HashTag(k); got != v {
+			t.Errorf("HashTag(%s) = %s, want %s", k, got, v)
+		}
+	}
+}
+
+func TestHashTags(t *testing.T) {
+	nodes := []string{"node1", "node2", "node3", "node4", "node5"}
+	tagged := NewMap(50, WithHashTags())
+	tagged.Set(nodes...)
+	plain := NewMap(50)
+	plain.Set(nodes...)
+
+	for i := 0; i < 100; i++ {
+		user := "{user:" + strconv.Itoa(i) + "}"
+		owner := tagged.Get(user + ":name")
+		for _, field := range []string{":age", ":email", ":orders"} {
+			if got := tagged.Get(user + field); got != owner {
+				t.Errorf("Get(%s) = %s, want %s like the other keys of the tag", user+field, got, owner)
+			}
+		}
+
+		// untagged keys are placed exactly like without hash tags
+		key := "user:" + strconv.Itoa(i)
+		if got, want := tagged.Get(key), plain.Get(key); got != want {
+			t.Errorf("Get(%s) = %s, want %s", key, got, want)
+		}
+	}
+}
+
+func TestSetWeight(t *testing.T) {
+	hash := NewMap(2, WithHash(func(key []byte) uint32 {
+		i, _ := strconv.Atoi(string(key))
+		return uint32(i)
+	}))
+	// 4, 14 and 6, 16, 26, 36
+	hash.Set("4")
+	hash.SetWeight(2, "6")
+	testCases := map[string]string{
+		"10": "4",
+		"20": "6",
+		"30": "6",
+		"40": "4",
+	}
+	for k, v := range testCases {
+		if hash.Get(k) != v {
+			t.Errorf("Asking for %s, should have yielded %s", k, v)
+		}
+	}
+
+	// back to 6, 16
+	hash.SetWeight(1, "6")
+	testCases["20"], testCases["30"] = "4", "4"
+	for k, v := range testCases {
+		if hash.Get(k) != v {
+			t.Errorf("Asking for %s, should have yielded %s", k, v)
+		}
+	}
+
+	hash.Remove("6")
+	if len(hash.keys) != 2 {
+		t.Errorf("%d virtual nodes left, want the 2 of 4", len(hash.keys))
+	}
+}
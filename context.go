@@ -0,0 +1,158 @@
+package cb_cache
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/cold-bin/cb-cache/serialization/pb"
+)
+
+const (
+	// headers propagating the caller's request across peer hops
+	timeoutHeader   = "X-Cb-Cache-Timeout" // remaining deadline in milliseconds
+	requestIDHeader = "X-Cb-Cache-Request-Id"
+	hopsHeader      = "X-Cb-Cache-Hops"
+	// marks a request already routed by the ring of the sender,
+	// the receiver loads the key locally instead of forwarding it again
+	routedHeader      = "X-Cb-Cache-Routed"
+	ringVersionHeader = "X-Cb-Cache-Ring-Version"
+
+	defaultMaxHops = 3
+)
+
+var (
+	ErrTooManyHops = errors.New("[cb-cache] request exceeds the hop limit")
+	ErrNoDeadline  = errors.New("[cb-cache] deadline of the caller exceeded")
+)
+
+type ctxKey int
+
+const (
+	requestIDKey ctxKey = iota
+	hopsKey
+	routedKey
+)
+
+// WithRequestID returns a copy of ctx carrying id, it is sent along with
+// every peer request made on behalf of ctx
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey, id)
+}
+
+// RequestID returns the request id of ctx, either set by WithRequestID
+// or received from the peer that forwarded the request
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey).(string)
+	return id
+}
+
+func hopsFrom(ctx context.Context) uint32 {
+	hops, _ := ctx.Value(hopsKey).(uint32)
+	return hops
+}
+
+// routed tells whether the request of ctx was forwarded by a peer
+// which has already picked this node for the key
+func routed(ctx context.Context) bool {
+	ok, _ := ctx.Value(routedKey).(bool)
+	return ok
+}
+
+func newRequestID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// newPeerRequest carries the request id, hop count and deadline of ctx,
+// and the ring version of picker if it has one
+func newPeerRequest(ctx context.Context, picker PeerPicker, group, key string) *pb.Request {
+	req := &pb.Request{
+		Group:     group,
+		Key:       key,
+		RequestId: RequestID(ctx),
+		Hops:      hopsFrom(ctx),
+		Routed:    true,
+	}
+	if rv, ok := picker.(RingVersioner); ok {
+		req.RingVersion = rv.RingVersion()
+	}
+	if req.RequestId == "" {
+		req.RequestId = newRequestID()
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		req.TimeoutMs = max(time.Until(deadline).Milliseconds(), 1)
+	}
+	return req
+}
+
+// setPropagationHeaders puts req on the wire, the remaining deadline is
+// taken from ctx at sending time if it has one
+func setPropagationHeaders(ctx context.Context, r *http.Request, req *pb.Request) {
+	timeout := req.GetTimeoutMs()
+	if deadline, ok := ctx.Deadline(); ok {
+		timeout = max(time.Until(deadline).Milliseconds(), 1)
+	}
+	if timeout > 0 {
+		r.Header.Set(timeoutHeader, strconv.FormatInt(timeout, 10))
+	}
+	if id := req.GetRequestId(); id != "" {
+		r.Header.Set(requestIDHeader, id)
+	}
+	r.Header.Set(hopsHeader, strconv.FormatUint(uint64(req.GetHops()), 10))
+	if req.GetRouted() {
+		r.Header.Set(routedHeader, "1")
+	}
+	if v := req.GetRingVersion(); v != 0 {
+		r.Header.Set(ringVersionHeader, strconv.FormatUint(v, 10))
+	}
+}
+
+// peerContext enforces the deadline, request id, hop count and routing mark
+// a peer sent along, the returned status is not 0 if the request must be rejected
+func (c *HTTPPool) peerContext(r *http.Request) (ctx context.Context, cancel context.CancelFunc, status int, err error) {
+	ctx, cancel = r.Context(), func() {}
+
+	var hops uint64
+	if s := r.Header.Get(hopsHeader); s != "" {
+		if hops, err = strconv.ParseUint(s, 10, 32); err != nil {
+			return ctx, cancel, http.StatusBadRequest, err
+		}
+		// this node is one more hop
+		hops++
+		if hops > uint64(c.maxHops) {
+			return ctx, cancel, http.StatusLoopDetected, ErrTooManyHops
+		}
+	}
+	ctx = context.WithValue(ctx, hopsKey, uint32(hops))
+
+	if id := r.Header.Get(requestIDHeader); id != "" {
+		ctx = WithRequestID(ctx, id)
+	}
+
+	if r.Header.Get(routedHeader) != "" {
+		ctx = context.WithValue(ctx, routedKey, true)
+	}
+	if s := r.Header.Get(ringVersionHeader); s != "" {
+		if v, err := strconv.ParseUint(s, 10, 64); err == nil {
+			c.checkRingVersion(v, r.RemoteAddr)
+		}
+	}
+
+	if s := r.Header.Get(timeoutHeader); s != "" {
+		ms, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return ctx, cancel, http.StatusBadRequest, err
+		}
+		if ms <= 0 {
+			return ctx, cancel, http.StatusGatewayTimeout, ErrNoDeadline
+		}
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(ms)*time.Millisecond)
+	}
+	return ctx, cancel, 0, nil
+}
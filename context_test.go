@@ -0,0 +1,86 @@
+package cb_cache
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestGroup_PropagatesRequest(t *testing.T) {
+	headers := make(chan http.Header, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		headers <- r.Header
+	}))
+	defer srv.Close()
+
+	pool := NewHTTPPool("http://self", 50)
+	defer pool.Close()
+	pool.Set(srv.URL)
+	g := NewGroup("propagate-client", 1<<10)
+	g.PutPeers(pool)
+
+	ctx, cancel := context.WithTimeout(WithRequestID(context.Background(), "req-1"), time.Second)
+	defer cancel()
+	g.Get(ctx, "key")
+
+	h := <-headers
+	if id := h.Get(requestIDHeader); id != "req-1" {
+		t.Errorf("request id = %q, want req-1", id)
+	}
+	if hops := h.Get(hopsHeader); hops != "0" {
+		t.Errorf("hops = %q, want 0", hops)
+	}
+	if ms, _ := strconv.Atoi(h.Get(timeoutHeader)); ms <= 0 || ms > 1000 {
+		t.Errorf("timeout = %q, want the remaining deadline", h.Get(timeoutHeader))
+	}
+}
+
+func TestHTTPPool_EnforcesPropagation(t *testing.T) {
+	type seen struct {
+		id       string
+		deadline bool
+		err      error
+	}
+	seens := make(chan seen, 1)
+	NewGroup("propagate-server", 1<<10, WithGetter(func(ctx context.Context, k string) ([]byte, error) {
+		_, ok := ctx.Deadline()
+		select {
+		case <-ctx.Done():
+		case <-time.After(time.Second):
+		}
+		seens <- seen{id: RequestID(ctx), deadline: ok, err: ctx.Err()}
+		return []byte("v"), nil
+	}))
+	pool := NewHTTPPool("http://self", 50)
+	defer pool.Close()
+
+	serve := func(header map[string]string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, DefaultBasePath+"propagate-server/key", nil)
+		for k, v := range header {
+			r.Header.Set(k, v)
+		}
+		w := httptest.NewRecorder()
+		pool.ServeHTTP(w, r)
+		return w
+	}
+
+	if w := serve(map[string]string{hopsHeader: strconv.Itoa(defaultMaxHops)}); w.Code != http.StatusLoopDetected {
+		t.Errorf("status = %d, want %d for too many hops", w.Code, http.StatusLoopDetected)
+	}
+	if w := serve(map[string]string{timeoutHeader: "0"}); w.Code != http.StatusGatewayTimeout {
+		t.Errorf("status = %d, want %d for an exceeded deadline", w.Code, http.StatusGatewayTimeout)
+	}
+
+	start := time.Now()
+	serve(map[string]string{timeoutHeader: "30", requestIDHeader: "req-2", hopsHeader: "0"})
+	s := <-seens
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("getter ran for %v, want the caller's deadline", elapsed)
+	}
+	if s.id != "req-2" || !s.deadline || s.err != context.DeadlineExceeded {
+		t.Errorf("getter saw %+v, want the caller's request id and deadline", s)
+	}
+}
@@ -0,0 +1,120 @@
+package cb_cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/cold-bin/cb-cache/serialization/pb"
+)
+
+// ErrNotFound is returned by a GetterFunc for keys that don't exist in the
+// source, possibly wrapped. The owner of a key answers not-found to its peers,
+// which return it without querying the source themselves.
+var ErrNotFound = errors.New("[cb-cache] key not found")
+
+// ErrUnknownGroup is answered by a peer which has no group of the name,
+// e.g. because it runs another build. It is a failure of the peer, unlike
+// the errors of the key.
+var ErrUnknownGroup = errors.New("[cb-cache] no such group")
+
+// PeerError is an error answered by a peer
+type PeerError struct {
+	Code pb.ErrorCode
+	Msg  string
+}
+
+func (e *PeerError) Error() string {
+	return fmt.Sprintf("[cb-cache] peer returned %v: %s", e.Code, e.Msg)
+}
+
+// Is makes errors.Is(err, ErrNotFound) and the context errors work across peers
+func (e *PeerError) Is(target error) bool {
+	switch e.Code {
+	case pb.ErrorCode_NOT_FOUND:
+		return target == ErrNotFound
+	case pb.ErrorCode_BAD_REQUEST:
+		return target == ErrKeyEmpty
+	case pb.ErrorCode_TIMEOUT:
+		return target == context.DeadlineExceeded
+	case pb.ErrorCode_UNKNOWN_GROUP:
+		return target == ErrUnknownGroup
+	default:
+		return false
+	}
+}
+
+// errorCode classifies err for the peer protocol
+func errorCode(err error) pb.ErrorCode {
+	switch {
+	case err == nil:
+		return pb.ErrorCode_OK
+	case errors.Is(err, ErrNotFound):
+		return pb.ErrorCode_NOT_FOUND
+	case errors.Is(err, ErrKeyEmpty):
+		return pb.ErrorCode_BAD_REQUEST
+	case errors.Is(err, ErrUnknownGroup):
+		return pb.ErrorCode_UNKNOWN_GROUP
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, ErrNoDeadline):
+		return pb.ErrorCode_TIMEOUT
+	case errors.Is(err, context.Canceled), errors.Is(err, ErrBreakerOpen), errors.Is(err, ErrGetterBusy):
+		return pb.ErrorCode_UNAVAILABLE
+	default:
+		return pb.ErrorCode_INTERNAL
+	}
+}
+
+// httpStatus of an error code
+func httpStatus(code pb.ErrorCode) int {
+	switch code {
+	case pb.ErrorCode_OK:
+		return http.StatusOK
+	case pb.ErrorCode_NOT_FOUND:
+		return http.StatusNotFound
+	case pb.ErrorCode_BAD_REQUEST:
+		return http.StatusBadRequest
+	case pb.ErrorCode_TIMEOUT:
+		return http.StatusGatewayTimeout
+	case pb.ErrorCode_UNAVAILABLE:
+		return http.StatusServiceUnavailable
+	case pb.ErrorCode_UNKNOWN_GROUP:
+		return http.StatusMisdirectedRequest
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// codeOfStatus is the inverse of httpStatus for peers not sending a code
+func codeOfStatus(status int) pb.ErrorCode {
+	switch status {
+	case http.StatusOK:
+		return pb.ErrorCode_OK
+	case http.StatusNotFound:
+		return pb.ErrorCode_NOT_FOUND
+	case http.StatusBadRequest:
+		return pb.ErrorCode_BAD_REQUEST
+	case http.StatusGatewayTimeout:
+		return pb.ErrorCode_TIMEOUT
+	case http.StatusServiceUnavailable:
+		return pb.ErrorCode_UNAVAILABLE
+	case http.StatusMisdirectedRequest:
+		return pb.ErrorCode_UNKNOWN_GROUP
+	default:
+		return pb.ErrorCode_INTERNAL
+	}
+}
+
+// answered tells whether err is a proper answer of the peer, like not-found,
+// rather than a failure to answer
+func answered(err error) bool {
+	return errors.Is(err, ErrNotFound) || errors.Is(err, ErrKeyEmpty)
+}
+
+// failure returns err unless the peer answered it
+func failure(err error) error {
+	if answered(err) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,106 @@
+package cb_cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/cold-bin/cb-cache/serialization"
+	"github.com/cold-bin/cb-cache/serialization/pb"
+)
+
+func TestHTTPPool_ErrorStatus(t *testing.T) {
+	NewGroup("error-status", 1<<10, WithGetter(func(ctx context.Context, k string) ([]byte, error) {
+		if k == "missing" {
+			return nil, fmt.Errorf("user %s: %w", k, ErrNotFound)
+		}
+		return nil, errors.New("database down")
+	}))
+	pool := NewHTTPPool("http://self", 50)
+	defer pool.Close()
+
+	testCases := []struct {
+		path   string
+		status int
+		code   pb.ErrorCode
+	}{
+		{"error-status/missing", http.StatusNotFound, pb.ErrorCode_NOT_FOUND},
+		{"error-status/broken", http.StatusInternalServerError, pb.ErrorCode_INTERNAL},
+		{"no-such-group/key", http.StatusMisdirectedRequest, pb.ErrorCode_UNKNOWN_GROUP},
+	}
+	for _, tc := range testCases {
+		w := httptest.NewRecorder()
+		pool.ServeHTTP(w, httptest.NewRequest(http.MethodGet, DefaultBasePath+tc.path, nil))
+		res := &pb.Response{}
+		if err := (&serialization.Protobuf{}).Unmarshal(w.Body.Bytes(), res); err != nil {
+			t.Fatal(err)
+		}
+		if w.Code != tc.status || res.GetCode() != tc.code {
+			t.Errorf("%s: got %d %v, want %d %v", tc.path, w.Code, res.GetCode(), tc.status, tc.code)
+		}
+	}
+}
+
+func TestGroup_PeerNotFound(t *testing.T) {
+	ownerPool := NewHTTPPool("http://owner", 50)
+	owner := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ownerPool.writeError(w, http.StatusNotFound, pb.ErrorCode_NOT_FOUND, ErrNotFound)
+	}))
+	defer owner.Close()
+
+	var origin int32
+	g := NewGroup("peer-not-found", 1<<10, WithGetter(func(ctx context.Context, k string) ([]byte, error) {
+		atomic.AddInt32(&origin, 1)
+		return []byte("v"), nil
+	}))
+	pool := NewHTTPPool("http://self", 50)
+	defer pool.Close()
+	pool.Set(owner.URL)
+	g.PutPeers(pool)
+
+	_, err := g.Get(context.Background(), "missing")
+	var perr *PeerError
+	if !errors.Is(err, ErrNotFound) || !errors.As(err, &perr) {
+		t.Errorf("Get error = %v, want the owner's not found", err)
+	}
+	if n := atomic.LoadInt32(&origin); n != 0 {
+		t.Errorf("origin queried %d times, the owner has done it already", n)
+	}
+	if g.Stats.PeerErrors != 0 {
+		t.Errorf("PeerErrors = %d, not found is no peer error", g.Stats.PeerErrors)
+	}
+}
+
+func TestErrorCode(t *testing.T) {
+	testCases := map[error]int{
+		ErrNotFound:                      http.StatusNotFound,
+		fmt.Errorf("w: %w", ErrNotFound): http.StatusNotFound,
+		ErrKeyEmpty:                      http.StatusBadRequest,
+		ErrUnknownGroup:                  http.StatusMisdirectedRequest,
+		context.DeadlineExceeded:         http.StatusGatewayTimeout,
+		ErrBreakerOpen:                   http.StatusServiceUnavailable,
+		errors.New("boom"):               http.StatusInternalServerError,
+	}
+	for err, status := range testCases {
+		if got := httpStatus(errorCode(err)); got != status {
+			t.Errorf("status of %v = %d, want %d", err, got, status)
+		}
+		if code := errorCode(err); codeOfStatus(httpStatus(code)) != code {
+			t.Errorf("code %v doesn't survive the status", code)
+		}
+	}
+}
+
+func TestPeerError_UnknownGroup(t *testing.T) {
+	err := error(&PeerError{Code: pb.ErrorCode_UNKNOWN_GROUP, Msg: "no such group: g"})
+	if !errors.Is(err, ErrUnknownGroup) || errors.Is(err, ErrKeyEmpty) {
+		t.Errorf("%v is taken for another error", err)
+	}
+	if answered(err) {
+		t.Error("a peer without the group is taken as an answer, the other replicas aren't tried")
+	}
+}
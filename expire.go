@@ -0,0 +1,48 @@
+package cb_cache
+
+import (
+	"math"
+	"time"
+)
+
+// WithExpirationJitter spreads expirations of values loaded together by
+// randomly adding or subtracting up to fraction of the ttl
+func WithExpirationJitter(fraction float64) GOption {
+	return func(g *Group) {
+		if fraction <= 0 || fraction >= 1 {
+			panic("expiration jitter must be in (0, 1)")
+		}
+		g.ttlJitter = fraction
+	}
+}
+
+// WithEarlyExpiration recomputes values probabilistically before they expire,
+// the more likely the closer to expiration and the longer they took to load
+// (XFetch). beta > 1 favors earlier recomputation, 1 is a good default.
+func WithEarlyExpiration(beta float64) GOption {
+	return func(g *Group) {
+		if beta <= 0 {
+			panic("early expiration beta must be greater than 0")
+		}
+		g.earlyBeta = beta
+	}
+}
+
+// expiration of a value loaded at now, jittered by r in [0, 1)
+func (g *Group) expiration(now time.Time, r float64) time.Time {
+	ttl := g.ttl
+	if g.ttlJitter > 0 {
+		ttl += time.Duration(float64(ttl) * g.ttlJitter * (2*r - 1))
+	}
+	return now.Add(ttl)
+}
+
+// expiresEarly tells whether value is recomputed at now with r in [0, 1),
+// i.e. now - delta * beta * ln(1-r) >= expiry
+func (g *Group) expiresEarly(now time.Time, value ByteView, r float64) bool {
+	if g.earlyBeta <= 0 || value.e.IsZero() || value.d <= 0 {
+		return false
+	}
+	gap := -float64(value.d) * g.earlyBeta * math.Log(1-r)
+	return float64(value.e.Sub(now)) <= gap
+}
@@ -0,0 +1,100 @@
+package cb_cache
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// simulateExpirations loads keys at once, reads every key each second and
+// reloads the expired ones or the ones expiring early, taking delta.
+// It returns the peak and total of origin loads per second.
+func simulateExpirations(g *Group, keys int, delta time.Duration, seconds int) (peak, total int) {
+	rnd := rand.New(rand.NewSource(1))
+	start := time.Unix(0, 0)
+	values := make([]ByteView, keys)
+	for i := range values {
+		values[i] = ByteView{e: g.expiration(start, rnd.Float64()), d: delta}
+	}
+
+	for s := 1; s <= seconds; s++ {
+		now := start.Add(time.Duration(s) * time.Second)
+		loads := 0
+		for i, v := range values {
+			if v.expired(now) || g.expiresEarly(now, v, rnd.Float64()) {
+				loads++
+				values[i] = ByteView{e: g.expiration(now.Add(delta), rnd.Float64()), d: delta}
+			}
+		}
+		peak, total = max(peak, loads), total+loads
+	}
+	return peak, total
+}
+
+func TestGroup_ExpirationSimulation(t *testing.T) {
+	const (
+		keys    = 5000
+		ttl     = 100 * time.Second
+		delta   = 2 * time.Second
+		seconds = 500
+	)
+	basePeak, baseTotal := simulateExpirations(&Group{ttl: ttl}, keys, delta, seconds)
+	if basePeak != keys {
+		t.Fatalf("all keys should expire together, peak %d", basePeak)
+	}
+
+	testCases := []struct {
+		name    string
+		g       *Group
+		maxPeak int
+	}{
+		{"jitter", &Group{ttl: ttl, ttlJitter: 0.1}, keys / 10},
+		{"xfetch", &Group{ttl: ttl, earlyBeta: 1}, keys / 2},
+		{"jitter+xfetch", &Group{ttl: ttl, ttlJitter: 0.1, earlyBeta: 1}, keys / 10},
+	}
+	for _, tc := range testCases {
+		peak, total := simulateExpirations(tc.g, keys, delta, seconds)
+		t.Logf("%s: peak %d, total %d (without: peak %d, total %d)", tc.name, peak, total, basePeak, baseTotal)
+		if peak > tc.maxPeak {
+			t.Errorf("%s: origin peak %d, want at most %d", tc.name, peak, tc.maxPeak)
+		}
+		// recomputing early costs some loads more
+		if float64(total) > float64(baseTotal)*1.3 {
+			t.Errorf("%s: %d origin loads, want about %d", tc.name, total, baseTotal)
+		}
+	}
+}
+
+func TestGroup_EarlyExpiration(t *testing.T) {
+	var loads int32
+	var down atomic.Bool
+	g := NewGroup("early-expiration", 1<<10,
+		WithExpiration(time.Hour),
+		WithEarlyExpiration(1e12), /*always early*/
+		WithGetter(func(ctx context.Context, k string) ([]byte, error) {
+			if down.Load() {
+				return nil, errors.New("database down")
+			}
+			atomic.AddInt32(&loads, 1)
+			time.Sleep(time.Millisecond)
+			return []byte("v"), nil
+		}))
+
+	for i := 0; i < 3; i++ {
+		if _, err := g.Get(context.Background(), "k"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := atomic.LoadInt32(&loads); n != 3 {
+		t.Errorf("getter called %d times, want every get to recompute", n)
+	}
+
+	// a failed early recomputation serves the value, which has not expired
+	down.Store(true)
+	if v, err := g.Get(context.Background(), "k"); err != nil || v.String() != "v" || v.Stale() {
+		t.Errorf("Get = %v %v, want fresh v", v, err)
+	}
+}
@@ -0,0 +1,123 @@
+package cb_cache
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/cold-bin/cb-cache/bloom"
+)
+
+// KeyLoader bulk loads the keys existing in the source by calling add for each
+type KeyLoader func(ctx context.Context, add func(key string)) error
+
+// keyFilter is the bloom filter of the keys known to exist.
+// Before the first build every key passes.
+type keyFilter struct {
+	n      uint64  // expected keys
+	p      float64 // false positive rate
+	loader KeyLoader
+
+	filter atomic.Pointer[bloom.Filter]
+
+	rebuild  sync.Mutex // one rebuild at a time
+	mu       sync.Mutex // protects building
+	building *bloom.Filter
+}
+
+// WithBloomFilter guards the getter with a bloom filter of the keys existing
+// in the source, sized for n keys at false positive rate p. The filter is
+// loaded by loader on RebuildBloomFilter and extended by Set, until the first
+// rebuild every key passes. Keys the filter doesn't have are ErrNotFound
+// without calling the getter.
+func WithBloomFilter(n uint64, p float64, loader KeyLoader) GOption {
+	return func(g *Group) {
+		if n == 0 || p <= 0 || p >= 1 || loader == nil {
+			panic("illegal bloom filter")
+		}
+		g.keys = &keyFilter{n: n, p: p, loader: loader}
+	}
+}
+
+// RebuildBloomFilter loads a new filter with the KeyLoader and swaps it in,
+// the old filter keeps serving meanwhile. Keys Set during the rebuild are
+// added to both.
+func (g *Group) RebuildBloomFilter(ctx context.Context) error {
+	f := g.keys
+	if f == nil {
+		return nil
+	}
+	f.rebuild.Lock()
+	defer f.rebuild.Unlock()
+
+	next := bloom.New(f.n, f.p)
+	f.mu.Lock()
+	f.building = next
+	f.mu.Unlock()
+
+	err := f.loader(ctx, next.Add)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.building = nil
+	if err != nil {
+		return err
+	}
+	f.filter.Store(next)
+	return nil
+}
+
+// Set stores v of k in the local cache and marks k existent in the bloom
+// filter, call it after k is written to the source
+func (g *Group) Set(k string, v []byte) error {
+	if k == "" {
+		return ErrKeyEmpty
+	}
+
+	if f := g.keys; f != nil {
+		f.mu.Lock()
+		if cur := f.filter.Load(); cur != nil {
+			cur.Add(k)
+		}
+		if f.building != nil {
+			f.building.Add(k)
+		}
+		f.mu.Unlock()
+	}
+	if g.negativeHit(k) {
+		g.negCache.set(k, ByteView{e: time.Now()} /*expired*/)
+	}
+	g.populateCache(k, ByteView{b: cloneBytes(v)}, &g.mainCache)
+	return nil
+}
+
+// mayExist tells whether k may exist in the source
+func (g *Group) mayExist(k string) bool {
+	if g.keys == nil {
+		return true
+	}
+	f := g.keys.filter.Load()
+	return f == nil || f.Has(k)
+}
+
+// BloomStats is the state of the bloom filter of a group
+type BloomStats struct {
+	Keys              uint64 // keys added, counting duplicates
+	Bits              uint64
+	Hashes            uint64
+	FalsePositiveRate float64 // estimated from the bits set
+	Rejects           uint64  // gets rejected as not found
+}
+
+func (g *Group) BloomStats() BloomStats {
+	s := BloomStats{Rejects: atomic.LoadUint64(&g.Stats.BloomRejects)}
+	if g.keys == nil {
+		return s
+	}
+	if f := g.keys.filter.Load(); f != nil {
+		s.Keys, s.Bits, s.Hashes = f.Len(), f.Bits(), f.Hashes()
+		s.FalsePositiveRate = f.FalsePositiveRate()
+	}
+	return s
+}
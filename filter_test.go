@@ -0,0 +1,115 @@
+package cb_cache
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync/atomic"
+	"testing"
+)
+
+func TestGroup_BloomFilter(t *testing.T) {
+	source := map[string]string{"Tom": "630", "Jack": "589"}
+	var origin int32
+	g := NewGroup("bloom-filter", 1<<10,
+		WithBloomFilter(1000, 0.001, func(ctx context.Context, add func(key string)) error {
+			for k := range source {
+				add(k)
+			}
+			return nil
+		}),
+		WithGetter(func(ctx context.Context, k string) ([]byte, error) {
+			atomic.AddInt32(&origin, 1)
+			if v, ok := source[k]; ok {
+				return []byte(v), nil
+			}
+			return nil, ErrNotFound
+		}))
+
+	// every key passes before the first build
+	if _, err := g.Get(context.Background(), "before"); !errors.Is(err, ErrNotFound) || origin != 1 {
+		t.Fatalf("Get = %v with %d origin queries, want the getter's not found", err, origin)
+	}
+
+	if err := g.RebuildBloomFilter(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := g.Get(context.Background(), "Tom"); err != nil || v.String() != "630" {
+		t.Fatalf("Get(Tom) = %v, %v", v, err)
+	}
+	for i := 0; i < 100; i++ {
+		if _, err := g.Get(context.Background(), "absent"+strconv.Itoa(i)); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("Get error = %v, want not found", err)
+		}
+	}
+	if n := atomic.LoadInt32(&origin); n > 3 {
+		t.Errorf("origin queried %d times, absent keys should be rejected", n)
+	}
+
+	stats := g.BloomStats()
+	if stats.Keys != 2 || stats.Rejects < 99 || stats.FalsePositiveRate <= 0 || stats.FalsePositiveRate > 0.001 {
+		t.Errorf("unexpected bloom stats %+v", stats)
+	}
+}
+
+func TestGroup_BloomFilterSet(t *testing.T) {
+	source := map[string]string{}
+	g := NewGroup("bloom-filter-set", 1<<10,
+		WithBloomFilter(1000, 0.001, func(ctx context.Context, add func(key string)) error {
+			for k := range source {
+				add(k)
+			}
+			return nil
+		}),
+		WithGetter(func(ctx context.Context, k string) ([]byte, error) {
+			if v, ok := source[k]; ok {
+				return []byte(v), nil
+			}
+			return nil, ErrNotFound
+		}))
+	if err := g.RebuildBloomFilter(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := g.Get(context.Background(), "Sam"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Get error = %v, want not found", err)
+	}
+
+	source["Sam"] = "567"
+	if err := g.Set("Sam", []byte("567")); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := g.Get(context.Background(), "Sam"); err != nil || v.String() != "567" {
+		t.Fatalf("Get(Sam) = %v, %v", v, err)
+	}
+
+	// the key survives a rebuild from the source
+	if err := g.RebuildBloomFilter(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if !g.mayExist("Sam") {
+		t.Error("Sam is lost by the rebuild")
+	}
+}
+
+func TestGroup_BloomFilterRebuildFails(t *testing.T) {
+	fail := false
+	g := NewGroup("bloom-filter-rebuild", 1<<10,
+		WithBloomFilter(1000, 0.001, func(ctx context.Context, add func(key string)) error {
+			add("Tom")
+			if fail {
+				return errors.New("database down")
+			}
+			return nil
+		}))
+	if err := g.RebuildBloomFilter(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	fail = true
+	if err := g.RebuildBloomFilter(context.Background()); err == nil {
+		t.Fatal("rebuild succeeded")
+	}
+	if !g.mayExist("Tom") || g.mayExist("Jack") {
+		t.Error("the old filter is not kept")
+	}
+}
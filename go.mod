@@ -3,6 +3,7 @@ module github.com/cold-bin/cb-cache
 go 1.21
 
 require (
+	github.com/go-zookeeper/zk v1.0.3
 	github.com/golang/protobuf v1.5.3
 	go.etcd.io/etcd/api/v3 v3.5.12
 	go.etcd.io/etcd/client/v3 v3.5.12
@@ -0,0 +1,161 @@
+package hashslot
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/cold-bin/cb-cache/consistencyhash"
+	"github.com/cold-bin/cb-cache/conv"
+)
+
+// DefaultSlots is the number of slots of Redis Cluster
+const DefaultSlots = 16384
+
+var (
+	ErrSlotRange     = errors.New("[cb-cache] slot out of range")
+	ErrSlotMigrating = errors.New("[cb-cache] slot is already migrating")
+	ErrNotMigrating  = errors.New("[cb-cache] slot is not migrating")
+)
+
+// Slot maps key to one of n slots with CRC16 like Redis Cluster,
+// keys sharing a {tag} map to the same slot.
+func Slot(key string, n int) int {
+	return int(crc16(conv.QuickS2B(consistencyhash.HashTag(key)))) % n
+}
+
+// Table is the explicit slot-to-node table of the cluster. Nodes are the
+// peer addresses as used by HTTPPool.
+// Table is not safe for concurrent modification, change a Clone and swap it.
+type Table struct {
+	Version uint64   // bumped on every change
+	Slots   []string // owner of every slot, "" if unassigned
+	// Migrating holds the slots moving to another node, slot -> target.
+	// The owner keeps serving the keys it has, the target the ones it doesn't.
+	Migrating map[int]string `json:",omitempty"`
+}
+
+// NewTable creates a table of n unassigned slots
+func NewTable(n int) *Table {
+	if n <= 0 {
+		panic("illegal slots")
+	}
+	return &Table{Slots: make([]string, n)}
+}
+
+// Len returns the number of slots
+func (t *Table) Len() int {
+	return len(t.Slots)
+}
+
+// Slot returns the slot of key in t
+func (t *Table) Slot(key string) int {
+	return Slot(key, len(t.Slots))
+}
+
+// Owner returns the node owning slot
+func (t *Table) Owner(slot int) string {
+	if slot < 0 || slot >= len(t.Slots) {
+		return ""
+	}
+	return t.Slots[slot]
+}
+
+// Target returns the node that slot is migrating to
+func (t *Table) Target(slot int) (node string, ok bool) {
+	node, ok = t.Migrating[slot]
+	return
+}
+
+// Assign gives the slots in [from, to] to node
+func (t *Table) Assign(from, to int, node string) error {
+	if from < 0 || to >= len(t.Slots) || from > to {
+		return ErrSlotRange
+	}
+	for slot := from; slot <= to; slot++ {
+		t.Slots[slot] = node
+	}
+	t.Version++
+	return nil
+}
+
+// Distribute assigns all slots evenly to nodes in contiguous ranges
+func (t *Table) Distribute(nodes ...string) {
+	if len(nodes) == 0 {
+		return
+	}
+	for slot := range t.Slots {
+		t.Slots[slot] = nodes[slot*len(nodes)/len(t.Slots)]
+	}
+	t.Migrating = nil
+	t.Version++
+}
+
+// Migrate starts moving slot to target
+func (t *Table) Migrate(slot int, target string) error {
+	if slot < 0 || slot >= len(t.Slots) {
+		return ErrSlotRange
+	}
+	if _, ok := t.Migrating[slot]; ok {
+		return ErrSlotMigrating
+	}
+	if t.Slots[slot] == target {
+		return fmt.Errorf("[cb-cache] slot %d is already owned by %s", slot, target)
+	}
+	if t.Migrating == nil {
+		t.Migrating = make(map[int]string)
+	}
+	t.Migrating[slot] = target
+	t.Version++
+	return nil
+}
+
+// Complete finishes the migration of slot, the target becomes its owner
+func (t *Table) Complete(slot int) error {
+	target, ok := t.Migrating[slot]
+	if !ok {
+		return ErrNotMigrating
+	}
+	t.Slots[slot] = target
+	delete(t.Migrating, slot)
+	t.Version++
+	return nil
+}
+
+// Cancel aborts the migration of slot, the owner stays unchanged
+func (t *Table) Cancel(slot int) error {
+	if _, ok := t.Migrating[slot]; !ok {
+		return ErrNotMigrating
+	}
+	delete(t.Migrating, slot)
+	t.Version++
+	return nil
+}
+
+// Clone returns a deep copy of t
+func (t *Table) Clone() *Table {
+	c := &Table{Version: t.Version, Slots: make([]string, len(t.Slots))}
+	copy(c.Slots, t.Slots)
+	if len(t.Migrating) > 0 {
+		c.Migrating = make(map[int]string, len(t.Migrating))
+		for slot, node := range t.Migrating {
+			c.Migrating[slot] = node
+		}
+	}
+	return c
+}
+
+// crc16 is CRC16-CCITT (XMODEM), the hash of Redis Cluster
+func crc16(b []byte) uint16 {
+	var crc uint16
+	for _, c := range b {
+		crc ^= uint16(c) << 8
+		for i := 0; i < 8; i++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
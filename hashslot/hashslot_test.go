@@ -0,0 +1,81 @@
+package hashslot
+
+import (
+	"testing"
+)
+
+func TestSlot(t *testing.T) {
+	// same slots as CLUSTER KEYSLOT of Redis
+	testCases := map[string]int{
+		"foo":            12182,
+		"bar":            5061,
+		"hello":          866,
+		"{user1000}.fol": 3443,
+		"user1000":       3443,
+	}
+	for k, v := range testCases {
+		if got := Slot(k, DefaultSlots); got != v {
+			t.Errorf("Slot(%s) = %d, want %d", k, got, v)
+		}
+	}
+}
+
+func TestTable_Distribute(t *testing.T) {
+	table := NewTable(DefaultSlots)
+	table.Distribute("a", "b", "c")
+
+	counts := map[string]int{}
+	for slot := 0; slot < table.Len(); slot++ {
+		counts[table.Owner(slot)]++
+	}
+	for _, node := range []string{"a", "b", "c"} {
+		if n := counts[node]; n < DefaultSlots/3 || n > DefaultSlots/3+1 {
+			t.Errorf("%s owns %d slots, want about %d", node, n, DefaultSlots/3)
+		}
+	}
+	if table.Owner(0) != "a" || table.Owner(DefaultSlots-1) != "c" {
+		t.Errorf("slots are not assigned in ranges")
+	}
+}
+
+func TestTable_Migrate(t *testing.T) {
+	table := NewTable(16)
+	if err := table.Assign(0, 15, "a"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := table.Migrate(3, "a"); err == nil {
+		t.Error("Migrate to the owner should fail")
+	}
+	if err := table.Migrate(3, "b"); err != nil {
+		t.Fatal(err)
+	}
+	if err := table.Migrate(3, "c"); err != ErrSlotMigrating {
+		t.Errorf("Migrate twice error = %v, want %v", err, ErrSlotMigrating)
+	}
+	if target, ok := table.Target(3); !ok || target != "b" {
+		t.Errorf("Target(3) = %s, %v, want b", target, ok)
+	}
+
+	clone := table.Clone()
+	if err := table.Complete(3); err != nil {
+		t.Fatal(err)
+	}
+	if owner := table.Owner(3); owner != "b" {
+		t.Errorf("Owner(3) = %s after migration, want b", owner)
+	}
+	if _, ok := table.Target(3); ok {
+		t.Error("slot 3 still migrating")
+	}
+	if err := table.Complete(3); err != ErrNotMigrating {
+		t.Errorf("Complete twice error = %v, want %v", err, ErrNotMigrating)
+	}
+
+	// the clone is not affected
+	if clone.Owner(3) != "a" || clone.Version == table.Version {
+		t.Error("Clone shares state with the table")
+	}
+	if err := clone.Cancel(3); err != nil || clone.Owner(3) != "a" {
+		t.Errorf("Cancel(3) = %v, owner %s, want a", err, clone.Owner(3))
+	}
+}
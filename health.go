@@ -0,0 +1,220 @@
+package cb_cache
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// healthPath is served by every pool for active probing
+const healthPath = "_health"
+
+const (
+	defaultOutlierInterval = time.Second
+	// of probed peers and ejected peers without WithEjection
+	defaultMaxFailures  = 3
+	defaultBaseEjection = 10 * time.Second
+	defaultMaxEjection  = 5 * time.Minute
+)
+
+// healthConfig decides when peers are ejected from PickPeer, zero values disable
+type healthConfig struct {
+	maxFailures   int           // consecutive failures before ejection
+	baseEjection  time.Duration // doubled for every ejection in a row
+	maxEjection   time.Duration
+	outlierFactor float64       // latency over factor * median of peers ejects
+	probeInterval time.Duration // interval of active probing
+}
+
+func (cfg *healthConfig) enabled() bool {
+	return cfg.maxFailures > 0 || cfg.outlierFactor > 0 || cfg.probeInterval > 0
+}
+
+// peerHealth tracks one peer. An ejected peer is skipped by PickPeer, the next
+// node on the ring takes over its keys, until its ejection time has passed.
+type peerHealth struct {
+	mu           sync.Mutex
+	failures     int           // consecutive failures
+	latency      time.Duration // moving average of successful requests
+	ejections    int           // consecutive ejections
+	ejectedUntil time.Time
+}
+
+func (h *peerHealth) record(cfg *healthConfig, latency time.Duration, err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	now := time.Now()
+	if err != nil {
+		h.failures++
+		if cfg.maxFailures > 0 && h.failures >= cfg.maxFailures && !now.Before(h.ejectedUntil) {
+			h.eject(cfg, now)
+		}
+		return
+	}
+
+	h.failures = 0
+	if h.latency == 0 {
+		h.latency = latency
+	} else {
+		h.latency = (h.latency*7 + latency) / 8
+	}
+	if !now.Before(h.ejectedUntil) {
+		// healthy again after re-admission
+		h.ejections = 0
+	}
+}
+
+// eject must be called with h.mu held
+func (h *peerHealth) eject(cfg *healthConfig, now time.Time) {
+	backoff := cfg.baseEjection << min(h.ejections, 16)
+	if backoff <= 0 || (cfg.maxEjection > 0 && backoff > cfg.maxEjection) {
+		backoff = cfg.maxEjection
+	}
+	h.ejectedUntil = now.Add(backoff)
+	h.ejections++
+	h.failures = 0
+	h.latency = 0 // measure again after re-admission
+}
+
+func (h *peerHealth) available(now time.Time) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return !now.Before(h.ejectedUntil)
+}
+
+// PeerStats is the state of one peer
+type PeerStats struct {
+	Zone      string
+	Ejected   bool
+	Ejections int // consecutive ejections
+	Failures  int // consecutive failures
+	Latency   time.Duration
+	Breaker   BreakerState
+}
+
+// PeerStats returns the state of every peer
+func (c *HTTPPool) PeerStats() map[string]PeerStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	stats := make(map[string]PeerStats, len(c.httpGetters))
+	for addr, g := range c.httpGetters {
+		g.health.mu.Lock()
+		stats[addr] = PeerStats{
+			Zone:      g.zone,
+			Ejected:   now.Before(g.health.ejectedUntil),
+			Ejections: g.health.ejections,
+			Failures:  g.health.failures,
+			Latency:   g.health.latency,
+			Breaker:   g.breaker.State(),
+		}
+		g.health.mu.Unlock()
+	}
+	return stats
+}
+
+// availableLocked must be called with c.mu held
+func (c *HTTPPool) availableLocked(addr string, now time.Time) bool {
+	g, ok := c.httpGetters[addr]
+	return !ok || g.health.available(now)
+}
+
+// monitor probes the peers and detects latency outliers until the pool is closed
+func (c *HTTPPool) monitor() {
+	interval := c.health.probeInterval
+	if interval <= 0 {
+		interval = defaultOutlierInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-ticker.C:
+			if c.health.probeInterval > 0 {
+				c.probe(interval)
+			}
+			if c.health.outlierFactor > 0 {
+				c.ejectOutliers()
+			}
+		}
+	}
+}
+
+// probe requests the health endpoint of every peer
+func (c *HTTPPool) probe(timeout time.Duration) {
+	c.mu.Lock()
+	getters := make([]*httpGetter, 0, len(c.httpGetters))
+	for _, g := range c.httpGetters {
+		getters = append(getters, g)
+	}
+	c.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for _, g := range getters {
+		wg.Add(1)
+		go func(g *httpGetter) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), timeout)
+			defer cancel()
+
+			start := time.Now()
+			err := g.probe(ctx)
+			g.health.record(&c.health, time.Since(start), err)
+		}(g)
+	}
+	wg.Wait()
+}
+
+// ejectOutliers ejects the peers much slower than the median of all peers
+func (c *HTTPPool) ejectOutliers() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	latencies := make([]time.Duration, 0, len(c.httpGetters))
+	for _, g := range c.httpGetters {
+		g.health.mu.Lock()
+		if g.health.latency > 0 && !now.Before(g.health.ejectedUntil) {
+			latencies = append(latencies, g.health.latency)
+		}
+		g.health.mu.Unlock()
+	}
+	if len(latencies) < 3 {
+		return
+	}
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	limit := time.Duration(float64(latencies[len(latencies)/2]) * c.health.outlierFactor)
+
+	for _, g := range c.httpGetters {
+		g.health.mu.Lock()
+		if g.health.latency > limit && !now.Before(g.health.ejectedUntil) {
+			g.health.eject(&c.health, now)
+		}
+		g.health.mu.Unlock()
+	}
+}
+
+// probe requests the health endpoint of the peer
+func (h *httpGetter) probe(ctx context.Context) error {
+	r, err := http.NewRequestWithContext(ctx, http.MethodGet, h.baseURL+healthPath, nil)
+	if err != nil {
+		return err
+	}
+	res, err := h.client().Do(r)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("server returned: %v", res.Status)
+	}
+	return nil
+}
@@ -0,0 +1,154 @@
+package cb_cache
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/cold-bin/cb-cache/serialization"
+	"github.com/cold-bin/cb-cache/serialization/pb"
+)
+
+func TestHTTPPool_Ejection(t *testing.T) {
+	bad := peerServer(t, http.StatusServiceUnavailable, "")
+	good := peerServer(t, http.StatusOK, "good")
+
+	pool := NewHTTPPool("http://self", 50, WithEjection(2, 100*time.Millisecond, time.Second))
+	defer pool.Close()
+	pool.Set(bad.URL, good.URL)
+
+	// find a key owned by the bad peer
+	var key string
+	for i := 0; ; i++ {
+		key = "key" + strconv.Itoa(i)
+		if peer, _ := pool.PickPeer(key); peer.(*httpGetter).baseURL == bad.URL+DefaultBasePath {
+			break
+		}
+	}
+
+	get := func() error {
+		peer, _ := pool.PickPeer(key)
+		_, err := peer.Get(context.Background(), &pb.Request{Group: "health", Key: key})
+		return err
+	}
+	for i := 0; i < 2; i++ {
+		if err := get(); err == nil {
+			t.Fatal("bad peer answered")
+		}
+	}
+
+	// ejected, the next node on the ring takes over
+	if err := get(); err != nil {
+		t.Errorf("Get after ejection error = %v", err)
+	}
+	if stats := pool.PeerStats()[bad.URL]; !stats.Ejected || stats.Ejections != 1 {
+		t.Errorf("PeerStats = %+v, want ejected once", stats)
+	}
+
+	// re-admitted after the backoff, and ejected twice as long on failures
+	time.Sleep(150 * time.Millisecond)
+	for i := 0; i < 2; i++ {
+		if err := get(); err == nil {
+			t.Fatal("bad peer answered")
+		}
+	}
+	if stats := pool.PeerStats()[bad.URL]; !stats.Ejected || stats.Ejections != 2 {
+		t.Errorf("PeerStats = %+v, want ejected twice", stats)
+	}
+}
+
+func TestHTTPPool_HealthProbe(t *testing.T) {
+	var down atomic.Bool
+	down.Store(true)
+	flaky := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if down.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer flaky.Close()
+	healthy := httptest.NewServer(NewHTTPPool("http://healthy", 50))
+	defer healthy.Close()
+
+	pool := NewHTTPPool("http://self", 50,
+		WithEjection(1, 50*time.Millisecond, 50*time.Millisecond),
+		WithHealthProbe(10*time.Millisecond))
+	defer pool.Close()
+	pool.Set(flaky.URL, healthy.URL)
+
+	time.Sleep(100 * time.Millisecond)
+	stats := pool.PeerStats()
+	if !stats[flaky.URL].Ejected {
+		t.Errorf("PeerStats[flaky] = %+v, want ejected without traffic", stats[flaky.URL])
+	}
+	if s := stats[healthy.URL]; s.Ejected || s.Latency == 0 {
+		t.Errorf("PeerStats[healthy] = %+v, want probed and not ejected", s)
+	}
+
+	down.Store(false)
+	time.Sleep(150 * time.Millisecond)
+	if s := pool.PeerStats()[flaky.URL]; s.Ejected || s.Ejections != 0 {
+		t.Errorf("PeerStats[flaky] = %+v, want re-admitted", s)
+	}
+}
+
+func TestHTTPPool_OutlierDetection(t *testing.T) {
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(50 * time.Millisecond)
+		bs, _ := (&serialization.Protobuf{}).Marshal(&pb.Response{Value: []byte("slow")})
+		w.Write(bs)
+	}))
+	defer slow.Close()
+	addrs := []string{slow.URL}
+	for i := 0; i < 3; i++ {
+		addrs = append(addrs, peerServer(t, http.StatusOK, "fast").URL)
+	}
+
+	// without WithEjection
+	pool := NewHTTPPool("http://self", 50, WithOutlierDetection(3))
+	defer pool.Close()
+	pool.Set(addrs...)
+
+	pool.mu.Lock()
+	getters := make([]*httpGetter, 0, len(addrs))
+	for _, addr := range addrs {
+		getters = append(getters, pool.httpGetters[addr])
+	}
+	pool.mu.Unlock()
+	for _, g := range getters {
+		if _, err := g.Get(context.Background(), &pb.Request{Group: "outlier", Key: "key"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	pool.ejectOutliers()
+	stats := pool.PeerStats()
+	if s := stats[slow.URL]; !s.Ejected || s.Ejections != 1 {
+		t.Errorf("PeerStats[slow] = %+v, want ejected once", s)
+	}
+	for _, addr := range addrs[1:] {
+		if s := stats[addr]; s.Ejected {
+			t.Errorf("PeerStats[%s] = %+v, want not ejected", addr, s)
+		}
+	}
+}
+
+func TestHTTPPool_HealthProbeDefaults(t *testing.T) {
+	down := peerServer(t, http.StatusServiceUnavailable, "")
+
+	// without WithEjection
+	pool := NewHTTPPool("http://self", 50, WithHealthProbe(10*time.Millisecond))
+	defer pool.Close()
+	pool.Set(down.URL)
+
+	deadline := time.Now().Add(time.Second)
+	for !pool.PeerStats()[down.URL].Ejected {
+		if time.Now().After(deadline) {
+			t.Fatalf("PeerStats = %+v, want the failing peer ejected", pool.PeerStats()[down.URL])
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,178 @@
+package cb_cache
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/cold-bin/cb-cache/serialization/pb"
+)
+
+const (
+	latencyWindowSize = 128 // peer latencies kept for percentiles
+	latencyMinSamples = 16  // samples needed before the percentile is used
+)
+
+// PickHedgePeer returns the peer a hedged request for key goes to: the next
+// replica of key, or the next node on the ring without replication.
+// If that is self, the key is loaded locally instead.
+func (c *HTTPPool) PickHedgePeer(key string) (PeerGetter, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.slots != nil || c.peers == nil {
+		return nil, false
+	}
+
+	now := time.Now()
+	getters, local := c.replicasLocked(key, now)
+	switch {
+	case local, len(getters) == 0:
+		return nil, false
+	case len(getters) > 1:
+		return getters[1], true
+	}
+
+	var hedge PeerGetter
+	c.peers.Walk(key, func(node string) bool {
+		if node == c.self {
+			return false
+		}
+		if g, ok := c.httpGetters[node]; ok && g != getters[0] && c.availableLocked(node, now) {
+			hedge = g
+			return false
+		}
+		return true
+	})
+	return hedge, hedge != nil
+}
+
+// latencyWindow keeps the latest latencies of peer requests
+type latencyWindow struct {
+	mu         sync.Mutex
+	samples    [latencyWindowSize]time.Duration
+	n          int   // total samples recorded
+	percentile int64 // cached time.Duration, updated every latencyMinSamples samples
+}
+
+func (w *latencyWindow) record(d time.Duration, p float64) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.samples[w.n%latencyWindowSize] = d
+	w.n++
+	if w.n%latencyMinSamples != 0 {
+		return
+	}
+
+	sorted := make([]time.Duration, min(w.n, latencyWindowSize))
+	copy(sorted, w.samples[:len(sorted)])
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	idx := int(p / 100 * float64(len(sorted)-1))
+	atomic.StoreInt64(&w.percentile, int64(sorted[idx]))
+}
+
+// delay to wait for the primary peer before hedging, 0 disables hedging
+func (g *Group) hedgeDelay() time.Duration {
+	if g.hedgePercentile > 0 {
+		if d := atomic.LoadInt64(&g.latencies.percentile); d > 0 {
+			return time.Duration(d)
+		}
+	}
+	return g.hedgeAfter
+}
+
+// getFromPeer asks peer for key. If hedging is enabled and peer has not answered
+// within the hedge delay, the same request is sent to the next replica, or the
+// local getter, and the first success wins. local is true if the result is
+// the local getter's, which has counted the load already.
+func (g *Group) getFromPeer(ctx context.Context, k string, peer PeerGetter, req *pb.Request) (_ *pb.Response, local bool, _ error) {
+	delay := g.hedgeDelay()
+	if delay <= 0 {
+		res, err := g.timedPeerGet(ctx, peer, req)
+		return res, false, err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel() // cancels the slower request
+
+	type result struct {
+		res   *pb.Response
+		err   error
+		hedge bool
+		local bool
+	}
+	results := make(chan result, 2)
+	go func() {
+		res, err := g.timedPeerGet(ctx, peer, req)
+		results <- result{res: res, err: err}
+	}()
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case r := <-results:
+		return r.res, false, r.err
+	case <-timer.C:
+	}
+
+	atomic.AddUint64(&g.Stats.HedgesFired, 1)
+	go func() {
+		var hedge PeerGetter
+		if hp, ok := g.peers.(HedgePicker); ok {
+			hedge, _ = hp.PickHedgePeer(k)
+		}
+		if hedge != nil {
+			res, err := hedge.Get(ctx, req)
+			results <- result{res: res, err: err, hedge: true}
+			return
+		}
+		if !g.mayExist(k) {
+			atomic.AddUint64(&g.Stats.BloomRejects, 1)
+			results <- result{err: ErrNotFound, hedge: true, local: true}
+			return
+		}
+		release, err := g.acquireGetter(ctx)
+		if err != nil {
+			results <- result{err: err, hedge: true, local: true}
+			return
+		}
+		bs, err := g.callGetter(ctx, k, release)
+		if err != nil {
+			atomic.AddUint64(&g.Stats.GetterFuncFailed, 1)
+			if errors.Is(err, ErrNotFound) {
+				g.populateNegative(k)
+			}
+		} else {
+			atomic.AddUint64(&g.Stats.GetterFuncFrom, 1)
+		}
+		results <- result{res: &pb.Response{Value: cloneBytes(bs)}, err: err, hedge: true, local: true}
+	}()
+
+	r := <-results
+	if r.err != nil && !answered(r.err) {
+		r = <-results
+	}
+	if r.err == nil && r.hedge {
+		atomic.AddUint64(&g.Stats.HedgesWon, 1)
+	}
+	return r.res, r.local, r.err
+}
+
+// timedPeerGet records the latency of successful peer requests
+// for percentile based hedging
+func (g *Group) timedPeerGet(ctx context.Context, peer PeerGetter, req *pb.Request) (*pb.Response, error) {
+	if g.hedgePercentile <= 0 {
+		return peer.Get(ctx, req)
+	}
+
+	start := time.Now()
+	res, err := peer.Get(ctx, req)
+	if err == nil {
+		g.latencies.record(time.Since(start), g.hedgePercentile)
+	}
+	return res, err
+}
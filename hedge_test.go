@@ -0,0 +1,123 @@
+package cb_cache
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/cold-bin/cb-cache/serialization/pb"
+)
+
+// slowPeer answers after delay, or fails when ctx is done
+type slowPeer struct {
+	delay time.Duration
+	value string
+}
+
+func (p *slowPeer) Get(ctx context.Context, req *pb.Request) (*pb.Response, error) {
+	select {
+	case <-time.After(p.delay):
+		return &pb.Response{Value: []byte(p.value)}, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+type hedgePicker struct {
+	primary, hedge PeerGetter
+}
+
+func (p *hedgePicker) PickPeer(key string) (PeerGetter, bool) {
+	return p.primary, true
+}
+
+func (p *hedgePicker) PickHedgePeer(key string) (PeerGetter, bool) {
+	return p.hedge, p.hedge != nil
+}
+
+func TestGroup_HedgeToReplica(t *testing.T) {
+	g := NewGroup("hedge-replica", 1<<10, WithHedging(20*time.Millisecond))
+	g.PutPeers(&hedgePicker{
+		primary: &slowPeer{delay: time.Second, value: "primary"},
+		hedge:   &slowPeer{value: "hedge"},
+	})
+
+	start := time.Now()
+	v, err := g.Get(context.Background(), "key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.String() != "hedge" {
+		t.Errorf("Get = %s, want the hedge's value", v)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("Get took %v, want about the hedge delay", elapsed)
+	}
+	if g.Stats.HedgesFired != 1 || g.Stats.HedgesWon != 1 {
+		t.Errorf("hedges fired %d won %d, want 1 and 1", g.Stats.HedgesFired, g.Stats.HedgesWon)
+	}
+}
+
+func TestGroup_HedgeToGetter(t *testing.T) {
+	buf := []byte("local")
+	g := NewGroup("hedge-getter", 1<<10, WithHedging(20*time.Millisecond),
+		WithGetter(func(ctx context.Context, k string) ([]byte, error) {
+			return buf, nil
+		}))
+	g.PutPeers(&hedgePicker{primary: &slowPeer{delay: time.Second}})
+
+	v, err := g.Get(context.Background(), "key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.String() != "local" {
+		t.Errorf("Get = %s, want the local getter's value", v)
+	}
+	if g.Stats.HedgesWon != 1 || g.Stats.GetterFuncFrom != 1 || g.Stats.PeerLoads != 0 {
+		t.Errorf("hedges won %d, getter loads %d, peer loads %d, want 1, 1 and 0",
+			g.Stats.HedgesWon, g.Stats.GetterFuncFrom, g.Stats.PeerLoads)
+	}
+	// the getter's buffer is not shared with the value
+	buf[0] = 'L'
+	if v.String() != "local" {
+		t.Errorf("Get = %s after the getter reused its buffer, want local", v)
+	}
+}
+
+func TestGroup_HedgePercentile(t *testing.T) {
+	g := NewGroup("hedge-percentile", 1<<10, WithHedgingPercentile(90, 100*time.Millisecond))
+	g.PutPeers(&hedgePicker{
+		primary: &slowPeer{delay: time.Millisecond, value: "primary"},
+		hedge:   &slowPeer{value: "hedge"},
+	})
+
+	for i := 0; i < 2*latencyMinSamples; i++ {
+		v, err := g.Get(context.Background(), "key"+strconv.Itoa(i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v.String() != "primary" && v.String() != "hedge" {
+			t.Fatalf("Get = %s, want a peer's value", v)
+		}
+	}
+	if d := g.hedgeDelay(); d >= 100*time.Millisecond || d < time.Millisecond {
+		t.Errorf("hedge delay = %v, want the observed percentile", d)
+	}
+}
+
+func TestHTTPPool_PickHedgePeer(t *testing.T) {
+	pool := NewHTTPPool("http://self", 50)
+	pool.Set("http://peer1", "http://peer2", "http://peer3")
+
+	for i := 0; i < 20; i++ {
+		key := "key" + strconv.Itoa(i)
+		primary, ok := pool.PickPeer(key)
+		if !ok {
+			continue
+		}
+		if hedge, ok := pool.PickHedgePeer(key); ok && hedge == primary {
+			t.Errorf("PickHedgePeer(%s) is the primary peer", key)
+		}
+	}
+}
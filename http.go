@@ -4,31 +4,69 @@ import (
 	"context"
 	"fmt"
 	"github.com/cold-bin/cb-cache/consistencyhash"
+	"github.com/cold-bin/cb-cache/hashslot"
 	"github.com/cold-bin/cb-cache/registry"
 	"github.com/cold-bin/cb-cache/serialization"
 	"github.com/cold-bin/cb-cache/serialization/pb"
 	"net/http"
+	"sort"
 	"strings"
 	"sync"
 	"sync/atomic"
+	"time"
 )
 
 const (
 	DefaultBasePath = "/_cb-cache/"
-	defaultReplicas = 50
+
+	defaultMaxIdleConnsPerPeer = 16
+
+	// between the attempts to resync with a registry
+	registryRetryInterval = time.Second
+	// of the deregistration of self when the pool stops
+	registryTimeout = 3 * time.Second
 )
 
 // HTTPPool implements PeerPicker for a pool of HTTP peers.
 type HTTPPool struct {
 	// this peers's base URL, e.g. "https://example.net:8000"
 	self     string
+	zone     string // availability zone of self, in-zone replicas are preferred
+	weight   int    // of self on the ring, published to the registry
+	version  string // build version of self, same-version replicas are preferred
+	started  time.Time
 	basePath string
 	replica  int
+	// number of peers holding each key, placed across distinct zones
+	replication int
 
 	peers       *consistencyhash.Map   // store all of peers
 	httpGetters map[string]*httpGetter // key marks different peers, like self
+	zoneStats   map[string]*ZoneStats  // requests sent to every zone
+
+	ringVersion    uint64 // fingerprint of the peers
+	ringMismatches uint64 // atomic, requests from peers with another ring version
+	lastMismatch   uint64 // atomic, last logged ring version of a peer
+
+	registry  registry.Health // of UseRegistry, if it tells its health
+	slots     *hashslot.Table // if set, keys are placed by slots instead of the ring
+	slotStore registry.Store  // where the slot table is published
+
+	client          *http.Client      // sends peer requests
+	transport       http.RoundTripper // of client, unless the client is injected
+	maxConnsPerPeer int               // limits of the default transport
+	idleConnTimeout time.Duration
+
+	health    healthConfig  // when peers are ejected
+	breaker   breakerConfig // circuit breaker of every peer
+	retry     retryConfig   // retries of PeerGetter.Get
+	done      chan struct{} // closed by Close
+	closeOnce sync.Once
+
+	maxHops int // peer requests forwarded more often are rejected
 
 	hashFn     consistencyhash.Hash
+	hashTags   bool                     // co-locate keys sharing a {tag}
 	serializer serialization.Serializer // dependency inject
 	mu         sync.Mutex
 }
@@ -41,12 +79,155 @@ func WithSerializer(codec serialization.Serializer) HPOpt {
 	}
 }
 
+// WithHTTPClient sends the peer requests with client instead of the pool's own
+func WithHTTPClient(client *http.Client) HPOpt {
+	return func(pool *HTTPPool) {
+		pool.client = client
+	}
+}
+
+// WithTransport sends the peer requests through rt instead of the pool's own
+// transport, e.g. for TLS, tracing or tests
+func WithTransport(rt http.RoundTripper) HPOpt {
+	return func(pool *HTTPPool) {
+		pool.transport = rt
+	}
+}
+
+// WithMaxConnsPerPeer limits the connections to every peer, including the
+// ones kept alive for reuse. Only applies to the pool's own transport.
+func WithMaxConnsPerPeer(n int) HPOpt {
+	return func(pool *HTTPPool) {
+		pool.maxConnsPerPeer = n
+	}
+}
+
+// WithIdleConnTimeout closes keep-alive connections idle for d.
+// Only applies to the pool's own transport.
+func WithIdleConnTimeout(d time.Duration) HPOpt {
+	return func(pool *HTTPPool) {
+		pool.idleConnTimeout = d
+	}
+}
+
+// WithMaxHops rejects peer requests which have already been forwarded
+// by n peers, this breaks forwarding loops when rings disagree
+func WithMaxHops(n int) HPOpt {
+	return func(pool *HTTPPool) {
+		pool.maxHops = n
+	}
+}
+
+// WithZone labels self with an availability zone.
+// self may also carry the label itself, see ZonedPeer
+func WithZone(zone string) HPOpt {
+	return func(pool *HTTPPool) {
+		pool.zone = zone
+	}
+}
+
+// WithWeight gives self weight times the share of the keys of a peer of
+// weight 1, e.g. for larger machines. Peers learn it from the registry.
+func WithWeight(weight int) HPOpt {
+	return func(pool *HTTPPool) {
+		pool.weight = weight
+	}
+}
+
+// WithVersion publishes the build version of self to the registry,
+// replicas of the same version are preferred
+func WithVersion(version string) HPOpt {
+	return func(pool *HTTPPool) {
+		pool.version = version
+	}
+}
+
+// WithReplication places every key on n peers in distinct zones (as far as
+// there are zones). Reads go to an in-zone replica first and fall back to
+// the remote zones only on failure.
+func WithReplication(n int) HPOpt {
+	return func(pool *HTTPPool) {
+		pool.replication = n
+	}
+}
+
+// WithHashTags makes keys containing a {tag} be placed by the tag only,
+// so e.g. all keys of one user can be fetched from a single peer.
+func WithHashTags() HPOpt {
+	return func(pool *HTTPPool) {
+		pool.hashTags = true
+	}
+}
+
+// WithEjection ejects a peer from PickPeer after maxFailures consecutive failed
+// requests, the next node on the ring takes over its keys. The peer is
+// re-admitted after base, doubled for every ejection in a row up to max.
+func WithEjection(maxFailures int, base, max time.Duration) HPOpt {
+	return func(pool *HTTPPool) {
+		pool.health.maxFailures = maxFailures
+		pool.health.baseEjection = base
+		pool.health.maxEjection = max
+	}
+}
+
+// WithOutlierDetection ejects the peers whose average latency is over
+// factor times the median latency of all peers. They are re-admitted as set
+// by WithEjection, after 10s doubled up to 5m without it.
+func WithOutlierDetection(factor float64) HPOpt {
+	return func(pool *HTTPPool) {
+		pool.health.outlierFactor = factor
+	}
+}
+
+// WithHealthProbe requests the health endpoint of every peer each interval,
+// so failing peers are ejected and recovered peers re-admitted without traffic.
+// Without WithEjection a peer is ejected after 3 failures in a row, for 10s
+// doubled up to 5m.
+func WithHealthProbe(interval time.Duration) HPOpt {
+	return func(pool *HTTPPool) {
+		pool.health.probeInterval = interval
+	}
+}
+
+// WithCircuitBreaker opens the breaker of a peer after threshold consecutive
+// failures, requests to it fail fast with ErrBreakerOpen. After openTimeout
+// the breaker becomes half-open and lets halfOpenRequests trial requests
+// pass, it closes again on success.
+func WithCircuitBreaker(threshold int, openTimeout time.Duration, halfOpenRequests int) HPOpt {
+	return func(pool *HTTPPool) {
+		pool.breaker = breakerConfig{
+			threshold:   threshold,
+			openTimeout: openTimeout,
+			halfOpenMax: halfOpenRequests,
+		}
+	}
+}
+
+// WithRetry tries a failed peer request up to attempts times in total,
+// waiting a jittered exponential backoff from base up to max in between.
+func WithRetry(attempts int, base, max time.Duration) HPOpt {
+	return func(pool *HTTPPool) {
+		pool.retry = retryConfig{
+			attempts:   attempts,
+			backoff:    base,
+			maxBackoff: max,
+		}
+	}
+}
+
 // NewHTTPPool initializes an HTTP pool of peers.
 func NewHTTPPool(self string, replica int, opts ...HPOpt) *HTTPPool {
+	self, zone := splitZone(self)
 	h := &HTTPPool{
-		self:     self,
-		basePath: DefaultBasePath,
-		replica:  replica,
+		self:        self,
+		zone:        zone,
+		basePath:    DefaultBasePath,
+		replica:     replica,
+		weight:      1,
+		replication: 1,
+		maxHops:     defaultMaxHops,
+		started:     time.Now(),
+		done:        make(chan struct{}),
 	}
 
 	for _, opt := range opts {
@@ -57,10 +238,41 @@ func NewHTTPPool(self string, replica int, opts ...HPOpt) *HTTPPool {
 		h.serializer = &serialization.Protobuf{}
 	}
 
+	if h.client == nil {
+		if h.transport == nil {
+			h.transport = h.newTransport()
+		}
+		h.client = &http.Client{Transport: h.transport}
+	}
+
 	if h.replica <= 0 {
 		panic("[cb-cache] illegal replica")
 	}
 
+	if h.replication <= 0 {
+		panic("[cb-cache] illegal replication")
+	}
+
+	if h.weight <= 0 {
+		panic("[cb-cache] illegal weight")
+	}
+
+	if h.breaker.threshold > 0 && h.breaker.halfOpenMax <= 0 {
+		h.breaker.halfOpenMax = 1
+	}
+
+	if h.health.probeInterval > 0 && h.health.maxFailures <= 0 {
+		h.health.maxFailures = defaultMaxFailures
+	}
+	if h.health.enabled() && h.health.baseEjection <= 0 && h.health.maxEjection <= 0 {
+		h.health.baseEjection = defaultBaseEjection
+		h.health.maxEjection = defaultMaxEjection
+	}
+
+	if h.health.outlierFactor > 0 || h.health.probeInterval > 0 {
+		go h.monitor()
+	}
+
 	return h
 }
 
@@ -72,6 +284,11 @@ func (c *HTTPPool) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		panic("[cb-cache] HTTPPool serving unexpected path: " + r.URL.Path)
 	}
 
+	if r.URL.Path[len(c.basePath):] == healthPath {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
 	ss := strings.SplitN(r.URL.Path[len(c.basePath):], "/", 2)
 	if len(ss) != 2 {
 		http.Error(w, "bad request", http.StatusBadRequest)
@@ -80,16 +297,34 @@ func (c *HTTPPool) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 	groupname, key := ss[0], ss[1]
 	group := GetGroup(groupname)
+	if group == nil {
+		c.writeError(w, http.StatusMisdirectedRequest, pb.ErrorCode_UNKNOWN_GROUP, fmt.Errorf("%w: %s", ErrUnknownGroup, groupname))
+		return
+	}
 	atomic.AddUint64(&group.Stats.ServerRequests, 1)
 
-	bv, err := group.Get(r.Context(), key)
+	if redirect, ok := c.slotRedirect(group, key, r); ok {
+		w.Header().Set(redirectHeader, redirect)
+		http.Error(w, redirect, http.StatusMisdirectedRequest)
+		return
+	}
+
+	ctx, cancel, status, err := c.peerContext(r)
+	defer cancel()
+	if status != 0 {
+		c.writeError(w, status, errorCode(err), err)
+		return
+	}
+
+	bv, err := group.Get(ctx, key)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		code := errorCode(err)
+		c.writeError(w, httpStatus(code), code, err)
 		return
 	}
 
 	// marshal
-	bs, err := c.serializer.Marshal(&pb.Response{Value: bv.ByteSlice()})
+	bs, err := c.serializer.Marshal(&pb.Response{Value: bv.ByteSlice(), Stale: bv.Stale()})
 	if err != nil {
 		return
 	}
@@ -101,50 +336,101 @@ func (c *HTTPPool) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// writeError answers a failed peer request with status and a pb.Response
+// carrying code, so the peer can tell e.g. not-found from a failure
+func (c *HTTPPool) writeError(w http.ResponseWriter, status int, code pb.ErrorCode, err error) {
+	bs, merr := c.serializer.Marshal(&pb.Response{Code: code, Error: err.Error()})
+	if merr != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.WriteHeader(status)
+	w.Write(bs)
+}
+
+// EtcdRegistry registers self at the etcd cluster at etcdAddrs and keeps
+// the peers in sync with it.
+//
+// Deprecated: use UseRegistry with registry.New.
 func (c *HTTPPool) EtcdRegistry(ctx context.Context, etcdAddrs ...string) error {
-	c.mu.Lock()
 	r, err := registry.New(ctx, "_cb-cache/", etcdAddrs)
 	if err != nil {
 		return err
 	}
-	if err := r.Register(ctx, c.self); err != nil {
+	return c.UseRegistry(ctx, r)
+}
+
+// Registry registers self at r and keeps the peers in sync with it.
+//
+// Deprecated: use UseRegistry.
+func (c *HTTPPool) Registry(ctx context.Context, r registry.Client) error {
+	return c.UseRegistry(ctx, r)
+}
+
+// UseRegistry registers self at r and keeps the peers in sync with the nodes
+// r discovers, until ctx is done or the pool is closed, self is deregistered
+// then. A closed watch is opened again and the peers are resynced from
+// the nodes of r, as events may have been lost meanwhile.
+// If r is a registry.NodeClient, self is published with its weight, version
+// and protocols, and the peers are placed by theirs.
+func (c *HTTPPool) UseRegistry(ctx context.Context, r registry.Client) error {
+	nr := registry.Nodes(r)
+	self := c.node()
+	if err := nr.RegisterNode(ctx, self); err != nil {
 		return err
 	}
-	watch := r.Watch(ctx)
-	// get all active peers
-	peers, err := r.GetAddress(ctx)
+	deregister := func() {
+		ctx, cancel := context.WithTimeout(context.Background(), registryTimeout)
+		defer cancel()
+		nr.Deregister(ctx, self.String())
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	watch, stopWatch, err := c.syncRegistry(ctx, nr)
 	if err != nil {
+		// peers must not route to a pool out of sync
+		cancel()
+		deregister()
 		return err
 	}
-	c.peers = consistencyhash.NewMap(defaultReplicas, consistencyhash.WithHash(c.hashFn))
-	c.peers.Set(peers...)
-	c.httpGetters = make(map[string]*httpGetter, len(peers))
-	for _, peer := range peers {
-		c.httpGetters[peer] = &httpGetter{baseURL: peer + c.basePath}
+	if h, ok := r.(registry.Health); ok {
+		c.mu.Lock()
+		c.registry = h
+		c.mu.Unlock()
 	}
-	c.mu.Unlock()
-	// watch etcd event and manager local peers
+
 	go func() {
+		defer func() {
+			if stopWatch != nil {
+				stopWatch()
+			}
+			cancel()
+			deregister()
+		}()
 		for {
 			select {
 			case <-ctx.Done():
 				return
+			case <-c.done:
+				return
 			case event, ok := <-watch:
-				// 通道已经被关闭
-				if !ok {
-					return
+				if !ok { /*the watch broke off*/
+					stopWatch()
+					if watch, stopWatch = c.resyncRegistry(ctx, nr, self); watch == nil {
+						return
+					}
+					continue
 				}
 				c.mu.Lock()
 				switch event.Type {
 				case registry.PUT:
-					c.peers.Set(event.Address)
-					c.httpGetters[event.Address] = &httpGetter{baseURL: event.Address + c.basePath}
+					c.setNodes(event.Node)
 				case registry.REMOVE:
-					c.peers.Remove(event.Address)
-					delete(c.httpGetters, event.Address)
+					c.removePeer(event.Node.Addr)
 				default:
 					panic(fmt.Sprintf("[cb-cache]: not support the type:%s", event.Type))
 				}
+				c.updateRingVersion()
 				c.mu.Unlock()
 			}
 		}
@@ -152,28 +438,239 @@ func (c *HTTPPool) EtcdRegistry(ctx context.Context, etcdAddrs ...string) error
 	return nil
 }
 
+// RegistryHealthy reports whether self is published by the registry of
+// UseRegistry, e.g. false while a lost etcd lease is recovered. It is true
+// without a registry or if the registry doesn't tell.
+func (c *HTTPPool) RegistryHealthy() bool {
+	c.mu.Lock()
+	h := c.registry
+	c.mu.Unlock()
+	return h == nil || h.Healthy()
+}
+
+// syncRegistry watches r and replaces the peers by the nodes of r,
+// stop ends the watch
+func (c *HTTPPool) syncRegistry(ctx context.Context, r registry.NodeClient) (watch <-chan registry.NodeEvent, stop context.CancelFunc, err error) {
+	// watch first, so no change after GetNodes is missed
+	ctx, stop = context.WithCancel(ctx)
+	watch = r.WatchNodes(ctx)
+	nodes, err := r.GetNodes(ctx)
+	if err != nil {
+		stop()
+		return nil, nil, err
+	}
+	c.mu.Lock()
+	c.resetPeers(nodes...)
+	c.mu.Unlock()
+	return watch, stop, nil
+}
+
+// resyncRegistry retries syncRegistry until it succeeds, it returns a nil
+// watch if ctx is done or the pool is closed before
+func (c *HTTPPool) resyncRegistry(ctx context.Context, r registry.NodeClient, self registry.Node) (<-chan registry.NodeEvent, context.CancelFunc) {
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, nil
+		case <-c.done:
+			return nil, nil
+		case <-time.After(registryRetryInterval):
+		}
+		// self may have gone with the registry
+		if err := r.RegisterNode(ctx, self); err != nil {
+			continue
+		}
+		if watch, stop, err := c.syncRegistry(ctx, r); err == nil {
+			return watch, stop
+		}
+	}
+}
+
 func (c *HTTPPool) Set(peers ...string) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	c.peers = consistencyhash.NewMap(c.replica, consistencyhash.WithHash(c.hashFn))
-	c.peers.Set(peers...)
+	old := c.httpGetters
+	c.peers = c.newRing(c.replica)
 	c.httpGetters = make(map[string]*httpGetter)
+	c.setPeers(peers...)
+	c.updateRingVersion()
+
+	// the transport can only close the idle connections of all peers at once,
+	// so they are closed when peers are replaced all together only
+	for addr := range old {
+		if _, ok := c.httpGetters[addr]; !ok {
+			c.client.CloseIdleConnections()
+			break
+		}
+	}
+}
+
+// resetPeers replaces the peers like Set, but keeps the getters, and so the
+// health of the peers, that are still there. must be called with c.mu held
+func (c *HTTPPool) resetPeers(nodes ...registry.Node) {
+	old := c.httpGetters
+	c.peers = c.newRing(c.replica)
+	c.httpGetters = make(map[string]*httpGetter, len(nodes))
+	for _, n := range nodes {
+		if g, ok := old[n.Addr]; ok && g.zone == n.Zone {
+			c.httpGetters[n.Addr] = g
+		}
+	}
+	c.setNodes(nodes...)
+	c.updateRingVersion()
+
+	// of all peers, see Set
+	for addr := range old {
+		if _, ok := c.httpGetters[addr]; !ok {
+			c.client.CloseIdleConnections()
+			break
+		}
+	}
+}
+
+func (c *HTTPPool) newRing(replica int) *consistencyhash.Map {
+	opts := []consistencyhash.MOpt{consistencyhash.WithHash(c.hashFn)}
+	if c.hashTags {
+		opts = append(opts, consistencyhash.WithHashTags())
+	}
+	return consistencyhash.NewMap(replica, opts...)
+}
+
+// setPeers adds peers, optionally labeled with a zone, to the ring.
+// must be called with c.mu held
+func (c *HTTPPool) setPeers(peers ...string) {
 	for _, peer := range peers {
-		c.httpGetters[peer] = &httpGetter{baseURL: fmt.Sprintf("%s%s", peer, c.basePath)}
+		c.setNodes(registry.ParseNode(peer))
+	}
+}
+
+// setNodes adds nodes to the ring by their weight, the ones self can't talk
+// to are left out. An existing getter of the same zone is kept.
+// must be called with c.mu held
+func (c *HTTPPool) setNodes(nodes ...registry.Node) {
+	for _, n := range nodes {
+		if n.Addr == c.self {
+			c.peers.SetWeight(max(n.Weight, 1), n.Addr)
+			continue
+		}
+		if !c.understands(n) {
+			c.removePeer(n.Addr)
+			continue
+		}
+		c.peers.SetWeight(max(n.Weight, 1), n.Addr)
+		g, ok := c.httpGetters[n.Addr]
+		if !ok || g.zone != n.Zone {
+			g = c.newGetter(n.Addr, n.Zone)
+			c.httpGetters[n.Addr] = g
+		}
+		g.version = n.Version
+	}
+}
+
+// removePeer must be called with c.mu held
+func (c *HTTPPool) removePeer(addr string) {
+	c.peers.Remove(addr)
+	// its idle connections time out, closing them here would drop the ones
+	// of all peers
+	delete(c.httpGetters, addr)
+}
+
+// must be called with c.mu held
+func (c *HTTPPool) newGetter(addr, zone string) *httpGetter {
+	return &httpGetter{
+		baseURL:    fmt.Sprintf("%s%s", addr, c.basePath),
+		zone:       zone,
+		stats:      c.zoneCounter(zone),
+		health:     &peerHealth{},
+		breaker:    &circuitBreaker{},
+		serializer: c.serializer,
+		pool:       c,
 	}
 }
 
-// PickPeer gets the closest peers, and then call get-function in this peers
+// getterLocked returns the getter of addr. Nodes which aren't known peers,
+// e.g. the target of a redirect, get a temporary one, so they don't join the
+// peers. must be called with c.mu held
+func (c *HTTPPool) getterLocked(addr string) *httpGetter {
+	if getter, ok := c.httpGetters[addr]; ok {
+		return getter
+	}
+	return c.newGetter(addr, "")
+}
+
+func (c *HTTPPool) getter(addr string) *httpGetter {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.getterLocked(addr)
+}
+
+// PickPeer gets the closest peers, and then call get-function in this peers.
+// If self is one of the replicas of key, the key is loaded locally.
 func (c *HTTPPool) PickPeer(key string) (PeerGetter, bool) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	if peer := c.peers.Get(key); peer != "" && peer != c.self {
-		getter, ok := c.httpGetters[peer]
-		getter.serializer = c.serializer
-		return getter, ok
+	if c.slots != nil {
+		return c.pickSlotPeer(key)
+	}
+
+	if c.peers == nil {
+		return nil, false
+	}
+
+	getters, local := c.replicasLocked(key, time.Now())
+	switch {
+	case local, len(getters) == 0:
+		return nil, false
+	case len(getters) == 1:
+		return getters[0], true
 	}
+	return getters, true
+}
+
+// replicasLocked returns the remote replicas of key in the order they are tried,
+// local is true if self is a replica. must be called with c.mu held
+func (c *HTTPPool) replicasLocked(key string, now time.Time) (getters replicaGetter, local bool) {
+	owners := c.owners(key, now)
+	getters = make(replicaGetter, 0, len(owners))
+	for _, owner := range owners {
+		if owner == c.self {
+			return nil, true
+		}
+		if getter, ok := c.httpGetters[owner]; ok {
+			getters = append(getters, getter)
+		}
+	}
+	// in-zone replicas first, then the ones of the same version,
+	// otherwise they keep their ring order
+	sort.SliceStable(getters, func(i, j int) bool {
+		return c.preference(getters[i]) < c.preference(getters[j])
+	})
+	return getters, false
+}
 
-	return nil, false
+// newTransport returns a keep-alive pool of connections to the peers, which
+// speaks HTTP/2 to TLS peers supporting it
+func (c *HTTPPool) newTransport() *http.Transport {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.ForceAttemptHTTP2 = true
+	t.MaxIdleConnsPerHost = defaultMaxIdleConnsPerPeer
+	if c.maxConnsPerPeer > 0 {
+		t.MaxConnsPerHost = c.maxConnsPerPeer
+		t.MaxIdleConnsPerHost = min(t.MaxIdleConnsPerHost, c.maxConnsPerPeer)
+	}
+	if c.idleConnTimeout > 0 {
+		t.IdleConnTimeout = c.idleConnTimeout
+	}
+	return t
+}
+
+// Close stops the background work of the pool and closes its idle connections
+func (c *HTTPPool) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.done)
+		c.client.CloseIdleConnections()
+	})
+	return nil
 }
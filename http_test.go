@@ -4,8 +4,11 @@ import (
 	"context"
 	"fmt"
 	"github.com/cold-bin/cb-cache/serialization"
+	"github.com/cold-bin/cb-cache/serialization/pb"
 	"log"
 	"net/http"
+	"net/http/httptest"
+	"strconv"
 	"testing"
 )
 
@@ -29,3 +32,90 @@ func TestNewHTTPPool(t *testing.T) {
 	log.Println("cb-cache is running at", addr)
 	http.ListenAndServe(addr, peers)
 }
+
+// peerServer answers every peer request with value, or with status if it's not 200
+func peerServer(t *testing.T, status int, value string) *httptest.Server {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if status != http.StatusOK {
+			http.Error(w, "unavailable", status)
+			return
+		}
+		bs, err := (&serialization.Protobuf{}).Marshal(&pb.Response{Value: []byte(value)})
+		if err != nil {
+			t.Error(err)
+		}
+		w.Write(bs)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestHTTPPool_ZoneAwarePick(t *testing.T) {
+	a := peerServer(t, http.StatusServiceUnavailable, "from a")
+	b1 := peerServer(t, http.StatusOK, "from b1")
+	b2 := peerServer(t, http.StatusOK, "from b2")
+
+	// self is not on the ring, so every key is owned by remote peers
+	pool := NewHTTPPool(ZonedPeer("http://self", "a"), 50, WithReplication(2))
+	pool.Set(ZonedPeer(a.URL, "a"), ZonedPeer(b1.URL, "b"), ZonedPeer(b2.URL, "b"))
+
+	const n = 20
+	for i := 0; i < n; i++ {
+		key := "key" + strconv.Itoa(i)
+		peer, ok := pool.PickPeer(key)
+		if !ok {
+			t.Fatalf("PickPeer(%s) found no peer", key)
+		}
+		replicas, ok := peer.(replicaGetter)
+		if !ok || len(replicas) != 2 {
+			t.Fatalf("PickPeer(%s) = %#v, want 2 replicas", key, peer)
+		}
+		if replicas[0].zone != "a" || replicas[1].zone != "b" {
+			t.Errorf("PickPeer(%s) zones = %s,%s, want a,b", key, replicas[0].zone, replicas[1].zone)
+		}
+
+		// the in-zone replica is down, so the remote zone answers
+		res, err := peer.Get(context.Background(), &pb.Request{Group: "zone", Key: key})
+		if err != nil {
+			t.Fatalf("Get(%s) error = %v", key, err)
+		}
+		if v := string(res.GetValue()); v != "from b1" && v != "from b2" {
+			t.Errorf("Get(%s) = %s, want a value from zone b", key, v)
+		}
+	}
+
+	stats := pool.ZoneStats()
+	if got, want := stats["a"], (ZoneStats{Requests: n, Failures: n}); got != want {
+		t.Errorf("ZoneStats[a] = %+v, want %+v", got, want)
+	}
+	if got, want := stats["b"], (ZoneStats{Requests: n}); got != want {
+		t.Errorf("ZoneStats[b] = %+v, want %+v", got, want)
+	}
+}
+
+func TestHTTPPool_LocalReplica(t *testing.T) {
+	pool := NewHTTPPool("http://self", 50, WithReplication(2))
+	pool.Set("http://self", "http://other")
+
+	// with two peers and two replicas, every key is also held by self
+	for i := 0; i < 20; i++ {
+		if peer, ok := pool.PickPeer("key" + strconv.Itoa(i)); ok {
+			t.Errorf("PickPeer picked %#v, want local load", peer)
+		}
+	}
+}
+
+func TestHTTPPool_HashTags(t *testing.T) {
+	pool := NewHTTPPool("http://self", 50, WithHashTags())
+	pool.Set("http://peer1", "http://peer2", "http://peer3", "http://peer4")
+
+	for i := 0; i < 20; i++ {
+		user := "{user:" + strconv.Itoa(i) + "}"
+		owner, _ := pool.PickPeer(user + ":name")
+		for _, field := range []string{":age", ":email"} {
+			if peer, _ := pool.PickPeer(user + field); peer != owner {
+				t.Errorf("PickPeer(%s) = %v, want %v", user+field, peer, owner)
+			}
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package cb_cache
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"time"
+)
+
+// ErrGetterBusy is returned when more callers wait for the getter than allowed
+var ErrGetterBusy = errors.New("[cb-cache] too many callers waiting for the getter")
+
+// getterLimiter bounds the calls of the getter
+type getterLimiter struct {
+	slots   chan struct{} // of concurrent calls, nil if unlimited
+	rate    *tokenBucket  // nil if unlimited
+	queue   int64         // max callers waiting
+	waiting int64         // atomically
+}
+
+// WithGetterConcurrency allows limit calls of the getter at once,
+// queue more callers wait and the others fail with ErrGetterBusy
+func WithGetterConcurrency(limit, queue int) GOption {
+	return func(g *Group) {
+		if limit <= 0 || queue < 0 {
+			panic("illegal getter concurrency")
+		}
+		if g.limiter == nil {
+			g.limiter = &getterLimiter{}
+		}
+		g.limiter.slots = make(chan struct{}, limit)
+		g.limiter.queue = int64(queue)
+	}
+}
+
+// WithGetterRate allows perSecond calls of the getter with bursts of burst,
+// callers beyond wait in the queue of WithGetterConcurrency,
+// DefaultQueueCap if not set
+func WithGetterRate(perSecond float64, burst int) GOption {
+	return func(g *Group) {
+		if perSecond <= 0 || burst <= 0 {
+			panic("illegal getter rate")
+		}
+		if g.limiter == nil {
+			g.limiter = &getterLimiter{queue: DefaultQueueCap}
+		}
+		g.limiter.rate = newTokenBucket(perSecond, burst)
+	}
+}
+
+// acquireGetter waits until the getter may be called,
+// release must be called after it returned, see callGetter
+func (g *Group) acquireGetter(ctx context.Context) (release func(), err error) {
+	l := g.limiter
+	if l == nil {
+		return func() {}, nil
+	}
+
+	token := l.rate == nil || l.rate.take(time.Now())
+	if token {
+		if l.slots == nil {
+			return func() {}, nil
+		}
+		select {
+		case l.slots <- struct{}{}:
+			return func() { <-l.slots }, nil
+		default:
+		}
+	}
+
+	// queue, a rejected caller must not take a token of the ones queued
+	if atomic.AddInt64(&l.waiting, 1) > l.queue {
+		atomic.AddInt64(&l.waiting, -1)
+		atomic.AddUint64(&g.Stats.GetterRejected, 1)
+		if token {
+			l.refund()
+		}
+		return nil, ErrGetterBusy
+	}
+	atomic.AddUint64(&g.Stats.GetterQueued, 1)
+	start := time.Now()
+	defer func() {
+		atomic.AddInt64(&l.waiting, -1)
+		atomic.AddUint64(&g.Stats.GetterWait, uint64(time.Since(start)))
+	}()
+
+	if !token {
+		timer := time.NewTimer(l.rate.reserve(time.Now()))
+		defer timer.Stop()
+		select {
+		case <-ctx.Done():
+			l.refund()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+	if l.slots == nil {
+		return func() {}, nil
+	}
+	select {
+	case <-ctx.Done():
+		l.refund()
+		return nil, ctx.Err()
+	case l.slots <- struct{}{}:
+		return func() { <-l.slots }, nil
+	}
+}
+
+// callGetter calls the getter in the slot of release, which is freed
+// also if the getter panics
+func (g *Group) callGetter(ctx context.Context, k string, release func()) ([]byte, error) {
+	defer release()
+	return g.getter(ctx, k)
+}
+
+// refund the token of a caller which doesn't call the getter
+func (l *getterLimiter) refund() {
+	if l.rate != nil {
+		l.rate.cancel()
+	}
+}
@@ -0,0 +1,154 @@
+package cb_cache
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/cold-bin/cb-cache/safe"
+)
+
+func TestGroup_GetterConcurrency(t *testing.T) {
+	var running, maxRunning int32
+	release := make(chan struct{})
+	g := NewGroup("getter-concurrency", 1<<10,
+		WithGetterConcurrency(2, 3),
+		WithGetter(func(ctx context.Context, k string) ([]byte, error) {
+			n := atomic.AddInt32(&running, 1)
+			defer atomic.AddInt32(&running, -1)
+			for {
+				m := atomic.LoadInt32(&maxRunning)
+				if n <= m || atomic.CompareAndSwapInt32(&maxRunning, m, n) {
+					break
+				}
+			}
+			<-release
+			return []byte(k), nil
+		}))
+
+	// 2 run, 3 wait and the others fail fast
+	const n = 8
+	errs := make(chan error, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, err := g.Get(context.Background(), "key"+strconv.Itoa(i))
+			errs <- err
+		}(i)
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	close(errs)
+
+	var busy int
+	for err := range errs {
+		if errors.Is(err, ErrGetterBusy) {
+			busy++
+		} else if err != nil {
+			t.Errorf("Get error = %v", err)
+		}
+	}
+	if busy != 3 {
+		t.Errorf("%d gets rejected, want 3", busy)
+	}
+	if m := atomic.LoadInt32(&maxRunning); m != 2 {
+		t.Errorf("%d getter calls at once, want 2", m)
+	}
+	if g.Stats.GetterQueued != 3 || g.Stats.GetterRejected != 3 || g.Stats.GetterWait == 0 {
+		t.Errorf("queued %d, rejected %d, waited %v, want 3, 3 and some wait",
+			g.Stats.GetterQueued, g.Stats.GetterRejected, time.Duration(g.Stats.GetterWait))
+	}
+}
+
+func TestGroup_GetterRate(t *testing.T) {
+	var calls int32
+	g := NewGroup("getter-rate", 1<<10,
+		WithGetterRate(50, 1),
+		WithGetter(func(ctx context.Context, k string) ([]byte, error) {
+			atomic.AddInt32(&calls, 1)
+			return []byte(k), nil
+		}))
+
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		if _, err := g.Get(context.Background(), "key"+strconv.Itoa(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 70*time.Millisecond {
+		t.Errorf("5 calls at 50/s took %v, want about 80ms", elapsed)
+	}
+
+	// a waiter gives up on its deadline
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	g.Get(context.Background(), "key5")
+	if _, err := g.Get(ctx, "key6"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Get error = %v, want deadline exceeded", err)
+	}
+}
+
+func TestGroup_GetterRateRefund(t *testing.T) {
+	getter := WithGetter(func(ctx context.Context, k string) ([]byte, error) {
+		return []byte(k), nil
+	})
+
+	// rejected callers take no tokens
+	g := NewGroup("getter-rate-rejected", 1<<10, WithGetterConcurrency(1, 0), WithGetterRate(10, 1), getter)
+	g.Get(context.Background(), "key")
+	for i := 0; i < 100; i++ {
+		if _, err := g.Get(context.Background(), "rejected"+strconv.Itoa(i)); !errors.Is(err, ErrGetterBusy) {
+			t.Fatalf("Get error = %v, want busy", err)
+		}
+	}
+	time.Sleep(110 * time.Millisecond)
+	if _, err := g.Get(context.Background(), "next"); err != nil {
+		t.Errorf("Get after the spike = %v, the bucket is in debt", err)
+	}
+
+	// neither do the ones giving up while waiting
+	g = NewGroup("getter-rate-cancelled", 1<<10, WithGetterRate(10, 1), getter)
+	g.Get(context.Background(), "key")
+	for i := 0; i < 100; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+		g.Get(ctx, "cancelled"+strconv.Itoa(i))
+		cancel()
+	}
+	time.Sleep(110 * time.Millisecond)
+	start := time.Now()
+	if _, err := g.Get(context.Background(), "next"); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed > 50*time.Millisecond {
+		t.Errorf("Get after the spike waited %v, the bucket is in debt", elapsed)
+	}
+}
+
+func TestGroup_GetterPanicRelease(t *testing.T) {
+	g := NewGroup("getter-panic-release", 1<<10,
+		WithGetterConcurrency(1, 0),
+		WithGetter(func(ctx context.Context, k string) ([]byte, error) {
+			if k == "panic" {
+				panic("getter failed")
+			}
+			return []byte(k), nil
+		}))
+
+	var pe *safe.PanicError
+	if _, err := g.Get(context.Background(), "panic"); !errors.As(err, &pe) {
+		t.Fatalf("Get error = %v, want the panic", err)
+	}
+	// the slot of the panicking call is free again
+	for i := 0; i < 3; i++ {
+		if _, err := g.Get(context.Background(), "key"+strconv.Itoa(i)); err != nil {
+			t.Errorf("Get after the panic = %v", err)
+		}
+	}
+}
@@ -76,14 +76,44 @@ func (c *cache) Get(k string) (v any, ok bool) {
 	}
 
 	if e, ok_ := c.activeMap[k]; ok_ { /*maybe in active list*/
+		entry := e.Value.(*Entry)
+		entry.cnt++
 		c.activeList.MoveToFront(e)
-		v, ok = e.Value.(*Entry).v, true
+		v, ok = entry.v, true
 		return
 	}
 	v, ok = nil, false
 	return
 }
 
+// Visits returns how many times k has been visited, i.e. its activity
+func (c *cache) Visits(k string) (n uint64, ok bool) {
+	if c.isNil() {
+		return
+	}
+	if e, ok_ := c.inactiveMap[k]; ok_ {
+		return e.Value.(*Entry).cnt, true
+	}
+	if e, ok_ := c.activeMap[k]; ok_ {
+		return e.Value.(*Entry).cnt, true
+	}
+	return
+}
+
+// Peek gets v of k without counting a visit
+func (c *cache) Peek(k string) (v any, ok bool) {
+	if c.isNil() {
+		return
+	}
+	if e, ok_ := c.inactiveMap[k]; ok_ {
+		return e.Value.(*Entry).v, true
+	}
+	if e, ok_ := c.activeMap[k]; ok_ {
+		return e.Value.(*Entry).v, true
+	}
+	return
+}
+
 func (c *cache) moveToRealCache(entry_ *Entry, e *list.Element) {
 	c.activeList.PushFront(entry_)
 	c.activeMap[entry_.k] = e
@@ -127,14 +157,18 @@ func (c *cache) RemoveOldest() {
 	if len(c.inactiveMap) != 0 {
 		e := c.inactiveList.Remove(c.inactiveList.Back()).(*Entry)
 		delete(c.inactiveMap, e.k)
-		c.onEliminate(e.k, e.v)
+		if c.onEliminate != nil {
+			c.onEliminate(e.k, e.v)
+		}
 		return
 	}
 
 	if len(c.activeMap) != 0 {
 		e := c.activeList.Remove(c.activeList.Back()).(*Entry)
 		delete(c.activeMap, e.k)
-		c.onEliminate(e.k, e.v)
+		if c.onEliminate != nil {
+			c.onEliminate(e.k, e.v)
+		}
 		return
 	}
 }
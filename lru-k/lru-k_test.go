@@ -129,3 +129,33 @@ func TestHotKey(t *testing.T) {
 		t.Fatalf("got %v in first evicted key; want %s", OnEliminateKeys[0], "myKey0")
 	}
 }
+
+func TestCache_Peek(t *testing.T) {
+	c := NewCache(2).(*cache)
+	c.Set("k", "v")
+	for i := 0; i < 3; i++ {
+		if v, ok := c.Peek("k"); !ok || v != "v" {
+			t.Fatalf("Peek(k) = %v, %v, want v", v, ok)
+		}
+	}
+	if _, ok := c.activeMap["k"]; ok {
+		t.Error("Peek counted visits")
+	}
+	if _, ok := c.Peek("missing"); ok {
+		t.Error("Peek(missing) found a value")
+	}
+}
+
+func TestCache_Visits(t *testing.T) {
+	c := NewCache(2).(*cache)
+	c.Set("k", "v")
+	for i := 0; i < 5; i++ {
+		c.Get("k")
+	}
+	if n, ok := c.Visits("k"); !ok || n != 5 {
+		t.Errorf("Visits(k) = %d, %v, want 5", n, ok)
+	}
+	if _, ok := c.Visits("missing"); ok {
+		t.Error("Visits(missing) found a key")
+	}
+}
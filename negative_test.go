@@ -0,0 +1,106 @@
+package cb_cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/cold-bin/cb-cache/serialization"
+	"github.com/cold-bin/cb-cache/serialization/pb"
+)
+
+func TestGroup_NegativeCache(t *testing.T) {
+	var origin int32
+	g := NewGroup("negative-cache", 1<<10,
+		WithNegativeCache(50*time.Millisecond, 1<<10),
+		WithGetter(func(ctx context.Context, k string) ([]byte, error) {
+			atomic.AddInt32(&origin, 1)
+			return nil, fmt.Errorf("user %s: %w", k, ErrNotFound)
+		}))
+
+	for i := 0; i < 3; i++ {
+		if _, err := g.Get(context.Background(), "missing"); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("Get error = %v, want not found", err)
+		}
+	}
+	if n := atomic.LoadInt32(&origin); n != 1 {
+		t.Errorf("origin queried %d times, want 1", n)
+	}
+	if g.Stats.NegativeHits != 2 {
+		t.Errorf("NegativeHits = %d, want 2", g.Stats.NegativeHits)
+	}
+	if g.CacheStates(NegativeCache).Hits == 0 {
+		t.Error("negative cache has no hits")
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	if _, err := g.Get(context.Background(), "missing"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Get error = %v, want not found", err)
+	}
+	if n := atomic.LoadInt32(&origin); n != 2 {
+		t.Errorf("origin queried %d times after expiry, want 2", n)
+	}
+}
+
+func TestGroup_NegativeCacheBytes(t *testing.T) {
+	g := NewGroup("negative-cache-bytes", 1<<10,
+		WithNegativeCache(time.Minute, 16),
+		WithGetter(func(ctx context.Context, k string) ([]byte, error) {
+			return nil, ErrNotFound
+		}))
+
+	for i := 0; i < 10; i++ {
+		g.Get(context.Background(), fmt.Sprintf("missing-%d", i))
+	}
+	if n := g.negCache.nBytes(); n > 16 {
+		t.Errorf("negative cache holds %d bytes, want at most 16", n)
+	}
+	if n := g.mainCache.nBytes(); n != 0 {
+		t.Errorf("main cache holds %d bytes, want none", n)
+	}
+}
+
+func TestGroup_NegativeCacheFromPeer(t *testing.T) {
+	var served int32
+	ownerPool := NewHTTPPool("http://owner", 50)
+	owner := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&served, 1)
+		ownerPool.writeError(w, http.StatusNotFound, pb.ErrorCode_NOT_FOUND, ErrNotFound)
+	}))
+	defer owner.Close()
+
+	g := NewGroup("negative-cache-peer", 1<<10,
+		WithNegativeCache(time.Minute, 1<<10),
+		WithGetter(func(ctx context.Context, k string) ([]byte, error) {
+			return []byte("v"), nil
+		}))
+	pool := NewHTTPPool("http://self", 50)
+	defer pool.Close()
+	pool.Set(owner.URL)
+	g.PutPeers(pool)
+
+	for i := 0; i < 3; i++ {
+		if _, err := g.Get(context.Background(), "missing"); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("Get error = %v, want not found", err)
+		}
+	}
+	if n := atomic.LoadInt32(&served); n != 1 {
+		t.Errorf("owner asked %d times, want 1", n)
+	}
+
+	// the owner answers cached not-found to its peers
+	w := httptest.NewRecorder()
+	pool.ServeHTTP(w, httptest.NewRequest(http.MethodGet, DefaultBasePath+"negative-cache-peer/missing", nil))
+	res := &pb.Response{}
+	if err := (&serialization.Protobuf{}).Unmarshal(w.Body.Bytes(), res); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusNotFound || res.GetCode() != pb.ErrorCode_NOT_FOUND {
+		t.Errorf("served %d %v, want not found", w.Code, res.GetCode())
+	}
+}
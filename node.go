@@ -0,0 +1,64 @@
+package cb_cache
+
+import (
+	"slices"
+
+	"github.com/cold-bin/cb-cache/registry"
+	"github.com/cold-bin/cb-cache/serialization"
+)
+
+// transport of the peer requests of HTTPPool
+const transportHTTP = "http"
+
+// node describes self to the registry
+func (c *HTTPPool) node() registry.Node {
+	n := registry.Node{
+		Addr:       c.self,
+		Zone:       c.zone,
+		Weight:     c.weight,
+		Version:    c.version,
+		Transports: []string{transportHTTP},
+		Started:    c.started,
+	}
+	if name := serializerName(c.serializer); name != "" {
+		n.Serializers = []string{name}
+	}
+	return n
+}
+
+// serializerName is the name of the serializers of this package,
+// "" for others
+func serializerName(s serialization.Serializer) string {
+	switch s.(type) {
+	case *serialization.Protobuf:
+		return "proto"
+	case *serialization.Json:
+		return "json"
+	case *serialization.Gob:
+		return "gob"
+	}
+	return ""
+}
+
+// understands reports whether self can send requests to n, a node which
+// doesn't tell its protocols is assumed to
+func (c *HTTPPool) understands(n registry.Node) bool {
+	if len(n.Transports) > 0 && !slices.Contains(n.Transports, transportHTTP) {
+		return false
+	}
+	name := serializerName(c.serializer)
+	return len(n.Serializers) == 0 || name == "" || slices.Contains(n.Serializers, name)
+}
+
+// preference orders the replicas of a key, lower first:
+// in-zone before remote zones, the same version before others
+func (c *HTTPPool) preference(g *httpGetter) int {
+	p := 0
+	if g.zone != c.zone {
+		p += 2
+	}
+	if g.version != c.version {
+		p++
+	}
+	return p
+}
@@ -8,6 +8,8 @@ import (
 	"io"
 	"net/http"
 	"net/url"
+	"sync/atomic"
+	"time"
 )
 
 // PeerPicker is the interface that must be implemented to locate
@@ -16,6 +18,18 @@ type PeerPicker interface {
 	PickPeer(key string) (peer PeerGetter, ok bool)
 }
 
+// HedgePicker is implemented by PeerPickers able to name a second peer
+// for key, which hedged requests are sent to.
+type HedgePicker interface {
+	PickHedgePeer(key string) (peer PeerGetter, ok bool)
+}
+
+// RingVersioner is implemented by PeerPickers identifying their view of the
+// cluster membership, peers with different versions may disagree on owners.
+type RingVersioner interface {
+	RingVersion() uint64
+}
+
 // PeerGetter is the interface that must be implemented by a peers.
 type PeerGetter interface {
 	Get(ctx context.Context, req *pb.Request) (_r *pb.Response, _err error)
@@ -23,24 +37,96 @@ type PeerGetter interface {
 
 type httpGetter struct {
 	baseURL    string
+	zone       string
+	version    string     // build version of the peer
+	stats      *ZoneStats // shared by all peers of zone
+	health     *peerHealth
+	breaker    *circuitBreaker
 	serializer serialization.Serializer
+	pool       *HTTPPool // resolves redirects to other peers
 }
 
 // Get send request to the closest server in order to get peer's cache data
 func (h *httpGetter) Get(ctx context.Context, req *pb.Request) (_r *pb.Response, _err error) {
-	res, err := http.Get(fmt.Sprintf(
+	if h.stats != nil {
+		atomic.AddUint64(&h.stats.Requests, 1)
+		defer func() {
+			if _err != nil {
+				atomic.AddUint64(&h.stats.Failures, 1)
+			}
+		}()
+	}
+
+	if h.pool == nil {
+		return h.get(ctx, req, false, 1)
+	}
+
+	for attempt := 0; ; attempt++ {
+		if _r, _err = h.try(ctx, req); _err == nil {
+			return _r, nil
+		}
+		if attempt+1 >= h.pool.retry.attempts || !retryable(ctx, _err) {
+			return nil, _err
+		}
+		if err := h.pool.retry.wait(ctx, attempt); err != nil {
+			return nil, _err
+		}
+	}
+}
+
+// try sends one request through the circuit breaker of the peer
+func (h *httpGetter) try(ctx context.Context, req *pb.Request) (_r *pb.Response, _err error) {
+	if h.breaker != nil && h.pool.breaker.threshold > 0 {
+		if err := h.breaker.allow(&h.pool.breaker, time.Now()); err != nil {
+			return nil, err
+		}
+		defer func() {
+			if _err != nil && ctx.Err() != nil /*the caller gave up, not the peer's fault*/ {
+				h.breaker.cancel()
+				return
+			}
+			h.breaker.done(&h.pool.breaker, time.Now(), failure(_err))
+		}()
+	}
+
+	if h.health != nil && h.pool.health.enabled() {
+		start := time.Now()
+		defer func() {
+			if ctx.Err() == nil /*the caller gave up, not the peer's fault*/ {
+				h.health.record(&h.pool.health, time.Since(start), failure(_err))
+			}
+		}()
+	}
+
+	return h.get(ctx, req, false, 1)
+}
+
+// get follows at most redirects MOVED or ASK responses of hash slot pools
+func (h *httpGetter) get(ctx context.Context, req *pb.Request, asking bool, redirects int) (_r *pb.Response, _err error) {
+	r, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf(
 		"%v%v/%v",
 		h.baseURL,
 		url.QueryEscape(req.GetGroup()),
 		url.QueryEscape(req.GetKey()),
-	))
+	), nil)
+	if err != nil {
+		return nil, err
+	}
+	setPropagationHeaders(ctx, r, req)
+	if asking {
+		r.Header.Set(askingHeader, "1")
+	}
+
+	res, err := h.client().Do(r)
 	if err != nil {
 		return nil, err
 	}
 	defer res.Body.Close()
 
-	if res.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("server returned: %v", res.Status)
+	if res.StatusCode == http.StatusMisdirectedRequest && redirects > 0 && h.pool != nil {
+		if kind, _, node, ok := parseRedirect(res.Header.Get(redirectHeader)); ok {
+			return h.pool.getter(node).get(ctx, req, kind == redirectAsk, redirects-1)
+		}
 	}
 
 	bytes, err := io.ReadAll(res.Body)
@@ -48,11 +134,41 @@ func (h *httpGetter) Get(ctx context.Context, req *pb.Request) (_r *pb.Response,
 		return nil, fmt.Errorf("reading response body: %v", err)
 	}
 
+	if res.StatusCode != http.StatusOK {
+		return nil, h.peerError(res, bytes)
+	}
+
 	// unmarshal
 	_r = &pb.Response{}
 	if err = h.serializer.Unmarshal(bytes, _r); err != nil {
 		return nil, err
 	}
+	if _r.GetCode() != pb.ErrorCode_OK {
+		return nil, &PeerError{Code: _r.GetCode(), Msg: _r.GetError()}
+	}
 
 	return _r, nil
 }
+
+// peerError decodes the error of a failed response, peers not sending
+// a pb.Response are classified by the status code
+func (h *httpGetter) peerError(res *http.Response, body []byte) error {
+	if res.Header.Get("Content-Type") == "application/octet-stream" {
+		r := &pb.Response{}
+		if err := h.serializer.Unmarshal(body, r); err == nil && r.GetCode() != pb.ErrorCode_OK {
+			return &PeerError{Code: r.GetCode(), Msg: r.GetError()}
+		}
+	}
+	code := codeOfStatus(res.StatusCode)
+	if code == pb.ErrorCode_OK {
+		code = pb.ErrorCode_INTERNAL
+	}
+	return &PeerError{Code: code, Msg: "server returned: " + res.Status}
+}
+
+func (h *httpGetter) client() *http.Client {
+	if h.pool != nil {
+		return h.pool.client
+	}
+	return http.DefaultClient
+}
@@ -0,0 +1,161 @@
+package cb_cache
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/cold-bin/cb-cache/serialization"
+	"github.com/cold-bin/cb-cache/serialization/pb"
+)
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+// idleCounter counts the calls to close the idle connections
+type idleCounter struct {
+	roundTripFunc
+	closed int32
+}
+
+func (c *idleCounter) CloseIdleConnections() {
+	atomic.AddInt32(&c.closed, 1)
+}
+
+func TestHTTPPool_CloseIdleConnections(t *testing.T) {
+	rt := &idleCounter{}
+	pool := NewHTTPPool("http://self", 50, WithTransport(rt))
+	pool.Set("http://a", "http://b", "http://c")
+
+	// a single removed peer keeps the connections of the others
+	pool.mu.Lock()
+	pool.removePeer("http://b")
+	pool.mu.Unlock()
+	if n := atomic.LoadInt32(&rt.closed); n != 0 {
+		t.Errorf("idle connections closed %d times on removing a peer, want 0", n)
+	}
+
+	pool.Set("http://a")
+	if n := atomic.LoadInt32(&rt.closed); n != 1 {
+		t.Errorf("idle connections closed %d times on Set, want 1", n)
+	}
+}
+
+func TestHTTPGetter_Transport(t *testing.T) {
+	srv := peerServer(t, http.StatusOK, "value")
+
+	var requests int32
+	pool := NewHTTPPool("http://self", 50, WithTransport(roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		atomic.AddInt32(&requests, 1)
+		return http.DefaultTransport.RoundTrip(r)
+	})))
+	defer pool.Close()
+	pool.Set(srv.URL)
+
+	peer, _ := pool.PickPeer("key")
+	if _, err := peer.Get(context.Background(), &pb.Request{Group: "client", Key: "key"}); err != nil {
+		t.Fatal(err)
+	}
+	if atomic.LoadInt32(&requests) != 1 {
+		t.Errorf("transport sent %d requests, want 1", requests)
+	}
+}
+
+func TestHTTPGetter_HTTP2(t *testing.T) {
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ProtoMajor != 2 {
+			http.Error(w, "want HTTP/2, got "+r.Proto, http.StatusHTTPVersionNotSupported)
+			return
+		}
+		bs, _ := (&serialization.Protobuf{}).Marshal(&pb.Response{Value: []byte("h2")})
+		w.Write(bs)
+	}))
+	srv.EnableHTTP2 = true
+	srv.StartTLS()
+	defer srv.Close()
+
+	pool := NewHTTPPool("https://self", 50, WithHTTPClient(srv.Client()))
+	pool.Set(srv.URL)
+
+	peer, _ := pool.PickPeer("key")
+	res, err := peer.Get(context.Background(), &pb.Request{Group: "client", Key: "key"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(res.GetValue()) != "h2" {
+		t.Errorf("Get = %s, want h2", res.GetValue())
+	}
+}
+
+func TestHTTPGetter_Context(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	pool := NewHTTPPool("http://self", 50)
+	defer pool.Close()
+	pool.Set(srv.URL)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	peer, _ := pool.PickPeer("key")
+	if _, err := peer.Get(ctx, &pb.Request{Group: "client", Key: "key"}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Get error = %v, want %v", err, context.DeadlineExceeded)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Get returned after %v, the deadline was ignored", elapsed)
+	}
+}
+
+func TestHTTPGetter_MaxConnsPerPeer(t *testing.T) {
+	var active, maxActive int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&active, 1)
+		defer atomic.AddInt32(&active, -1)
+		for {
+			m := atomic.LoadInt32(&maxActive)
+			if n <= m || atomic.CompareAndSwapInt32(&maxActive, m, n) {
+				break
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+		bs, _ := (&serialization.Protobuf{}).Marshal(&pb.Response{Value: []byte("ok")})
+		w.Write(bs)
+	}))
+	defer srv.Close()
+
+	pool := NewHTTPPool("http://self", 50, WithMaxConnsPerPeer(2), WithIdleConnTimeout(time.Second))
+	defer pool.Close()
+	pool.Set(srv.URL)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			peer, _ := pool.PickPeer("key")
+			if _, err := peer.Get(context.Background(), &pb.Request{Group: "client", Key: "key"}); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if got := atomic.LoadInt32(&maxActive); got > 2 {
+		t.Errorf("peer served %d requests at once, want at most 2", got)
+	}
+}
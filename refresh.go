@@ -0,0 +1,167 @@
+package cb_cache
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const defaultRefreshWorkers = 4
+
+// refreshAhead reloads hot keys in the background before they expire
+type refreshAhead struct {
+	fraction  float64 // of the ttl left when a hot key is refreshed
+	minVisits uint64  // visits of the lru-k cache making a key hot
+	workers   int
+	limit     *tokenBucket // nil if not limited
+
+	start  sync.Once
+	queue  chan string
+	queued sync.Map // keys in queue or being refreshed
+}
+
+// WithRefreshAhead reloads keys visited at least minVisits times, as counted
+// by the lru-k cache, once they are read within fraction of the expiration
+// set by WithExpiration, so readers of hot keys never wait for the getter.
+func WithRefreshAhead(fraction float64, minVisits uint64) GOption {
+	return func(g *Group) {
+		if fraction <= 0 || fraction >= 1 {
+			panic("refresh-ahead fraction must be in (0, 1)")
+		}
+		if g.refresh == nil {
+			g.refresh = &refreshAhead{workers: defaultRefreshWorkers}
+		}
+		g.refresh.fraction = fraction
+		g.refresh.minVisits = minVisits
+	}
+}
+
+// WithRefreshWorkers bounds the refresh-ahead reloads to workers running at
+// once and perSecond starting per second, 0 for no rate limit
+func WithRefreshWorkers(workers int, perSecond float64) GOption {
+	return func(g *Group) {
+		if workers <= 0 || perSecond < 0 {
+			panic("illegal refresh workers")
+		}
+		if g.refresh == nil {
+			g.refresh = &refreshAhead{}
+		}
+		g.refresh.workers = workers
+		if perSecond > 0 {
+			g.refresh.limit = newTokenBucket(perSecond, 1)
+		}
+	}
+}
+
+// refreshAhead enqueues k for a background reload if it is hot and about to expire
+func (g *Group) refreshAhead(k string, value ByteView, now time.Time) {
+	r := g.refresh
+	if r == nil || r.fraction <= 0 || value.e.IsZero() {
+		return
+	}
+	if value.e.Sub(now) > time.Duration(r.fraction*float64(g.ttl)) {
+		return
+	}
+	if n, ok := g.mainCache.visits(k); !ok || n < r.minVisits {
+		return
+	}
+	if _, queued := r.queued.LoadOrStore(k, struct{}{}); queued {
+		return
+	}
+
+	r.start.Do(func() {
+		r.queue = make(chan string, DefaultQueueCap)
+		for i := 0; i < r.workers; i++ {
+			go g.refreshWorker()
+		}
+	})
+	select {
+	case r.queue <- k:
+	default: /*full, the key is read again soon if it's hot*/
+		r.queued.Delete(k)
+		atomic.AddUint64(&g.Stats.RefreshDrops, 1)
+	}
+}
+
+func (g *Group) refreshWorker() {
+	r := g.refresh
+	for k := range r.queue {
+		if r.limit != nil {
+			r.limit.wait(context.Background())
+		}
+		_, err := g.loader.Once(k, func() (any, error) {
+			return g.load(context.Background(), k)
+		})
+		r.queued.Delete(k)
+		if err == nil {
+			atomic.AddUint64(&g.Stats.RefreshAheads, 1)
+		}
+	}
+}
+
+// tokenBucket limits events to rate per second with bursts of burst
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate float64, burst int) *tokenBucket {
+	return &tokenBucket{rate: rate, burst: float64(burst), tokens: float64(burst), last: time.Now()}
+}
+
+// reserve takes a token and returns how long to wait until it is available
+func (b *tokenBucket) reserve(now time.Time) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.tokens = min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+	b.tokens--
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// take takes a token if one is available now
+func (b *tokenBucket) take(now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.tokens = min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// cancel gives back a token taken by take or reserve but not used
+func (b *tokenBucket) cancel() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.tokens = min(b.burst, b.tokens+1)
+}
+
+// wait blocks until a token is available or ctx is done
+func (b *tokenBucket) wait(ctx context.Context) error {
+	d := b.reserve(time.Now())
+	if d <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,69 @@
+package cb_cache
+
+import (
+	"context"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestGroup_RefreshAhead(t *testing.T) {
+	loads := map[string]*int32{"hot": new(int32), "cold": new(int32)}
+	g := NewGroup("refresh-ahead", 1<<10,
+		WithExpiration(100*time.Millisecond),
+		WithRefreshAhead(0.5, 3),
+		WithRefreshWorkers(1, 0),
+		WithGetter(func(ctx context.Context, k string) ([]byte, error) {
+			n := atomic.AddInt32(loads[k], 1)
+			return []byte("v" + strconv.Itoa(int(n))), nil
+		}))
+
+	for i := 0; i < 4; i++ {
+		g.Get(context.Background(), "hot")
+	}
+	g.Get(context.Background(), "cold")
+	time.Sleep(60 * time.Millisecond)
+
+	// within the last half of the ttl
+	for _, k := range []string{"hot", "cold"} {
+		if v, err := g.Get(context.Background(), k); err != nil || v.String() != "v1" {
+			t.Fatalf("Get(%s) = %v %v, want v1", k, v, err)
+		}
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for atomic.LoadInt32(loads["hot"]) < 2 {
+		if time.Now().After(deadline) {
+			t.Fatal("hot key is not refreshed")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	time.Sleep(10 * time.Millisecond)
+	if v, err := g.Get(context.Background(), "hot"); err != nil || v.String() != "v2" || v.Stale() {
+		t.Errorf("Get(hot) = %v %v, want fresh v2", v, err)
+	}
+	if n := atomic.LoadInt32(loads["cold"]); n != 1 {
+		t.Errorf("cold key loaded %d times, want 1", n)
+	}
+	if n := atomic.LoadUint64(&g.Stats.RefreshAheads); n != 1 {
+		t.Errorf("RefreshAheads = %d, want 1", n)
+	}
+}
+
+func TestTokenBucket(t *testing.T) {
+	now := time.Now()
+	b := newTokenBucket(10, 2)
+	b.last = now
+	for i := 0; i < 2; i++ {
+		if d := b.reserve(now); d != 0 {
+			t.Fatalf("burst token %d waits %v", i, d)
+		}
+	}
+	if d := b.reserve(now); d != 100*time.Millisecond {
+		t.Errorf("third token waits %v, want 100ms", d)
+	}
+	if d := b.reserve(now.Add(time.Second)); d != 0 {
+		t.Errorf("token after refill waits %v", d)
+	}
+}
@@ -1,3 +1,286 @@
 package registry
 
-// TODO consul registry and discovery
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// 阻塞查询的最长等待时间
+	consulWait = 5 * time.Minute
+	// 请求超时, consul会给阻塞查询的等待时间加上至多wait/16的随机抖动
+	consulTimeout = consulWait + consulWait/16 + 10*time.Second
+	// 节点失联多久后被consul注销
+	consulDeregisterAfter = time.Minute
+)
+
+// consul registers nodes as instances of a service of the local consul agent,
+// each with a TTL check kept passing while it runs
+type consul struct {
+	mu sync.Mutex
+
+	ctx     context.Context // of the registry, stops the heartbeats
+	client  *http.Client
+	agent   string // address of the agent, e.g. http://localhost:8500
+	service string
+	beats   map[string]context.CancelFunc // addr -> heartbeat
+}
+
+type consulCheck struct {
+	CheckID                        string
+	TTL                            string
+	DeregisterCriticalServiceAfter string
+}
+
+type consulService struct {
+	ID      string
+	Name    string `json:",omitempty"`
+	Service string `json:",omitempty"`
+	Address string
+	Port    int
+	Meta    map[string]string
+	Check   *consulCheck `json:",omitempty"`
+}
+
+type consulEntry struct {
+	Service consulService
+}
+
+// NewConsul returns the registry of service at the consul agent, the
+// heartbeats of the registered nodes stop when ctx is done
+func NewConsul(ctx context.Context, service, agent string) (Client, error) {
+	if _, err := url.Parse(agent); err != nil {
+		return nil, err
+	}
+	return &consul{
+		ctx:     ctx,
+		client:  &http.Client{Timeout: consulTimeout},
+		agent:   agent,
+		service: service,
+		beats:   make(map[string]context.CancelFunc),
+	}, nil
+}
+
+// id of the instance of addr, addr may contain characters not allowed in ids
+func (r *consul) id(addr string) string {
+	h := fnv.New64a()
+	h.Write([]byte(addr))
+	return fmt.Sprintf("%s-%x", r.service, h.Sum64())
+}
+
+func (r *consul) do(ctx context.Context, method, path string, query url.Values, body any) (*http.Response, error) {
+	var rd io.Reader
+	if body != nil {
+		bs, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		rd = bytes.NewReader(bs)
+	}
+
+	u := r.agent + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, rd)
+	if err != nil {
+		return nil, err
+	}
+	res, err := r.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(res.Body)
+		res.Body.Close()
+		return nil, fmt.Errorf("[cb-cache] consul %s %s: %s: %s", method, path, res.Status, msg)
+	}
+	return res, nil
+}
+
+// Register addr with a TTL check and keep it passing
+func (r *consul) Register(ctx context.Context, addr string) error {
+	return r.RegisterNode(ctx, ParseNode(addr))
+}
+
+// RegisterNode publishes node in the meta of its instance
+func (r *consul) RegisterNode(ctx context.Context, node Node) error {
+	addr := node.String()
+	svc := consulService{
+		ID:   r.id(addr),
+		Name: r.service,
+		Meta: map[string]string{"addr": addr, "node": node.Encode()},
+		Check: &consulCheck{
+			CheckID:                        "service:" + r.id(addr),
+			TTL:                            (keepAliveTTL * time.Second).String(),
+			DeregisterCriticalServiceAfter: consulDeregisterAfter.String(),
+		},
+	}
+	if u, err := url.Parse(node.Addr); err == nil {
+		svc.Address = u.Hostname()
+		svc.Port, _ = strconv.Atoi(u.Port())
+	}
+
+	res, err := r.do(ctx, http.MethodPut, "/v1/agent/service/register", nil, svc)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	if err := r.pass(ctx, addr); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if cancel, ok := r.beats[addr]; ok {
+		cancel()
+	}
+	beatCtx, cancel := context.WithCancel(r.ctx)
+	r.beats[addr] = cancel
+	go r.heartbeat(beatCtx, addr)
+	return nil
+}
+
+func (r *consul) pass(ctx context.Context, addr string) error {
+	res, err := r.do(ctx, http.MethodPut, "/v1/agent/check/pass/service:"+r.id(addr), nil, nil)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	return nil
+}
+
+// heartbeat passes the TTL check of addr three times per TTL
+func (r *consul) heartbeat(ctx context.Context, addr string) {
+	ticker := time.NewTicker(keepAliveTTL * time.Second / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			// a failed beat is retried by the next one within the TTL
+			r.pass(ctx, addr)
+		}
+	}
+}
+
+func (r *consul) Deregister(ctx context.Context, addr string) error {
+	addr = ParseNode(addr).String()
+	r.mu.Lock()
+	if cancel, ok := r.beats[addr]; ok {
+		cancel()
+		delete(r.beats, addr)
+	}
+	r.mu.Unlock()
+
+	res, err := r.do(ctx, http.MethodPut, "/v1/agent/service/deregister/"+r.id(addr), nil, nil)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	return nil
+}
+
+// health returns the passing nodes, blocking until the consul index passes
+// index if it is not 0
+func (r *consul) health(ctx context.Context, index uint64) ([]Node, uint64, error) {
+	query := url.Values{"passing": {"true"}}
+	if index > 0 {
+		query.Set("index", strconv.FormatUint(index, 10))
+		query.Set("wait", consulWait.String())
+	}
+	res, err := r.do(ctx, http.MethodGet, "/v1/health/service/"+url.PathEscape(r.service), query, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer res.Body.Close()
+
+	var entries []consulEntry
+	if err := json.NewDecoder(res.Body).Decode(&entries); err != nil {
+		return nil, 0, err
+	}
+	nodes := make([]Node, 0, len(entries))
+	for _, e := range entries {
+		// instances registered before the descriptors have the address only
+		if node, ok := e.Service.Meta["node"]; ok {
+			nodes = append(nodes, ParseNode(node))
+		} else if addr, ok := e.Service.Meta["addr"]; ok {
+			nodes = append(nodes, ParseNode(addr))
+		}
+	}
+	next, _ := strconv.ParseUint(res.Header.Get("X-Consul-Index"), 10, 64)
+	return nodes, next, nil
+}
+
+// GetAddress get all passing node's address
+func (r *consul) GetAddress(ctx context.Context) ([]string, error) {
+	nodes, err := r.GetNodes(ctx)
+	return addresses(nodes), err
+}
+
+// GetNodes get all passing nodes
+func (r *consul) GetNodes(ctx context.Context) ([]Node, error) {
+	nodes, _, err := r.health(ctx, 0)
+	return nodes, err
+}
+
+// Watch the addresses of the passing nodes
+func (r *consul) Watch(ctx context.Context) <-chan Event {
+	return watchAddresses(ctx, r.WatchNodes(ctx))
+}
+
+// WatchNodes watches the passing nodes by blocking queries and sends the
+// differences, starting with the nodes of the first query as puts, so nothing
+// changed between a GetNodes and the watch is missed
+func (r *consul) WatchNodes(ctx context.Context) <-chan NodeEvent {
+	ch := make(chan NodeEvent, eventChanSize)
+	go func() {
+		defer close(ch)
+
+		var (
+			index uint64
+			known = map[string]Node{}
+		)
+		for ctx.Err() == nil {
+			nodes, next, err := r.health(ctx, index)
+			if err != nil {
+				select {
+				case <-ctx.Done():
+				case <-time.After(retryInterval):
+				}
+				continue
+			}
+			// the index may go backwards, e.g. after a consul restart
+			if next < index {
+				next = 0
+			}
+			index = next
+			if index == 0 { /*no blocking query, don't spin*/
+				select {
+				case <-ctx.Done():
+				case <-time.After(retryInterval):
+				}
+			}
+
+			current := make(map[string]Node, len(nodes))
+			for _, n := range nodes {
+				current[n.Addr] = n
+			}
+			if !send(ctx, ch, diff(known, current)) {
+				return
+			}
+			known = current
+		}
+	}()
+	return ch
+}
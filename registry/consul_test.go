@@ -0,0 +1,255 @@
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeConsul serves the endpoints of the consul agent used by the registry
+type fakeConsul struct {
+	mu       sync.Mutex
+	index    uint64
+	changed  chan struct{} // closed on every change
+	services map[string]consulService
+	passing  map[string]bool // check id -> passed
+}
+
+func newFakeConsul() *fakeConsul {
+	return &fakeConsul{
+		index:    1,
+		changed:  make(chan struct{}),
+		services: make(map[string]consulService),
+		passing:  make(map[string]bool),
+	}
+}
+
+// change must be called with f.mu held
+func (f *fakeConsul) change() {
+	f.index++
+	close(f.changed)
+	f.changed = make(chan struct{})
+}
+
+func (f *fakeConsul) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	switch {
+	case r.Method == http.MethodPut && r.URL.Path == "/v1/agent/service/register":
+		var svc consulService
+		if err := json.NewDecoder(r.Body).Decode(&svc); err != nil || svc.Check == nil || svc.Check.TTL == "" {
+			http.Error(w, "bad service", http.StatusBadRequest)
+			return
+		}
+		f.services[svc.ID] = svc
+		f.change()
+	case r.Method == http.MethodPut && strings.HasPrefix(r.URL.Path, "/v1/agent/check/pass/"):
+		id := strings.TrimPrefix(r.URL.Path, "/v1/agent/check/pass/")
+		if !f.passing[id] {
+			f.passing[id] = true
+			f.change()
+		}
+	case r.Method == http.MethodPut && strings.HasPrefix(r.URL.Path, "/v1/agent/service/deregister/"):
+		delete(f.services, strings.TrimPrefix(r.URL.Path, "/v1/agent/service/deregister/"))
+		f.change()
+	case r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, "/v1/health/service/"):
+		// blocking query
+		if index, _ := strconv.ParseUint(r.URL.Query().Get("index"), 10, 64); index >= f.index {
+			changed := f.changed
+			f.mu.Unlock()
+			select {
+			case <-changed:
+			case <-r.Context().Done():
+			case <-time.After(time.Second):
+			}
+			f.mu.Lock()
+		}
+		name := strings.TrimPrefix(r.URL.Path, "/v1/health/service/")
+		entries := []consulEntry{}
+		for _, svc := range f.services {
+			if svc.Name == name && f.passing[svc.Check.CheckID] {
+				entries = append(entries, consulEntry{Service: consulService{
+					ID: svc.ID, Service: svc.Name, Address: svc.Address, Port: svc.Port, Meta: svc.Meta,
+				}})
+			}
+		}
+		w.Header().Set("X-Consul-Index", strconv.FormatUint(f.index, 10))
+		json.NewEncoder(w).Encode(entries)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// fail the TTL check of addr as consul does when heartbeats stop
+func (f *fakeConsul) fail(checkID string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.passing, checkID)
+	f.change()
+}
+
+func TestConsul(t *testing.T) {
+	fake := newFakeConsul()
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	r, err := NewConsul(ctx, "cb-cache", srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := r.Register(ctx, "http://localhost:8001"); err != nil {
+		t.Fatal(err)
+	}
+	watch := r.Watch(ctx)
+	addrs, err := r.GetAddress(ctx)
+	if err != nil || len(addrs) != 1 || addrs[0] != "http://localhost:8001" {
+		t.Fatalf("GetAddress = %v, %v", addrs, err)
+	}
+
+	next := func() Event {
+		select {
+		case e := <-watch:
+			return e
+		case <-time.After(2 * time.Second):
+			t.Fatal("no event")
+			return Event{}
+		}
+	}
+
+	// the watch starts with the nodes there
+	if e := next(); e != (Event{Address: "http://localhost:8001", Type: PUT}) {
+		t.Errorf("event = %+v, want put of 8001", e)
+	}
+
+	if err := r.Register(ctx, "http://localhost:8002?zone=b"); err != nil {
+		t.Fatal(err)
+	}
+	if e := next(); e != (Event{Address: "http://localhost:8002?zone=b", Type: PUT}) {
+		t.Errorf("event = %+v, want put of 8002", e)
+	}
+
+	if err := r.Deregister(ctx, "http://localhost:8001"); err != nil {
+		t.Fatal(err)
+	}
+	if e := next(); e != (Event{Address: "http://localhost:8001", Type: REMOVE}) {
+		t.Errorf("event = %+v, want remove of 8001", e)
+	}
+
+	// a node failing its check is removed
+	fake.fail("service:" + r.(*consul).id("http://localhost:8002?zone=b"))
+	if e := next(); e != (Event{Address: "http://localhost:8002?zone=b", Type: REMOVE}) {
+		t.Errorf("event = %+v, want remove of 8002", e)
+	}
+
+	cancel()
+	for range watch {
+	}
+}
+
+func TestConsul_Register(t *testing.T) {
+	fake := newFakeConsul()
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	r, _ := NewConsul(context.Background(), "cb-cache", srv.URL)
+	for _, addr := range []string{"http://10.0.0.1:8001", "http://10.0.0.2:8001?zone=b"} {
+		if err := r.Register(context.Background(), addr); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	fake.mu.Lock()
+	var hosts []string
+	for _, svc := range fake.services {
+		hosts = append(hosts, svc.Address+":"+strconv.Itoa(svc.Port))
+		if svc.Check.TTL != "10s" {
+			t.Errorf("check TTL = %s, want 10s", svc.Check.TTL)
+		}
+	}
+	fake.mu.Unlock()
+	sort.Strings(hosts)
+	if strings.Join(hosts, ",") != "10.0.0.1:8001,10.0.0.2:8001" {
+		t.Errorf("registered %v", hosts)
+	}
+}
+
+func TestConsul_Nodes(t *testing.T) {
+	fake := newFakeConsul()
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	r, _ := NewConsul(ctx, "cb-cache", srv.URL)
+	nr := r.(NodeClient)
+
+	node := Node{Addr: "http://localhost:8001", Zone: "a", Weight: 2, Version: "v1", Transports: []string{"http"}}
+	if err := nr.RegisterNode(ctx, node); err != nil {
+		t.Fatal(err)
+	}
+	watch := nr.WatchNodes(ctx)
+	nodes, err := nr.GetNodes(ctx)
+	if err != nil || len(nodes) != 1 || !nodes[0].equal(node) {
+		t.Fatalf("GetNodes = %+v, %v", nodes, err)
+	}
+	if addrs, _ := r.GetAddress(ctx); len(addrs) != 1 || addrs[0] != "http://localhost:8001?zone=a" {
+		t.Errorf("GetAddress = %v", addrs)
+	}
+
+	next := func() NodeEvent {
+		select {
+		case e := <-watch:
+			return e
+		case <-time.After(2 * time.Second):
+			t.Fatal("no event")
+			return NodeEvent{}
+		}
+	}
+	if e := next(); e.Type != PUT || !e.Node.equal(node) {
+		t.Errorf("event = %+v, want put of v1", e)
+	}
+
+	// publishing another descriptor puts the node again
+	node.Version = "v2"
+	if err := nr.RegisterNode(ctx, node); err != nil {
+		t.Fatal(err)
+	}
+	if e := next(); e.Type != PUT || !e.Node.equal(node) {
+		t.Errorf("event = %+v, want put of v2", e)
+	}
+}
+
+func TestConsul_WatchFirstSnapshot(t *testing.T) {
+	fake := newFakeConsul()
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	r, _ := NewConsul(ctx, "cb-cache", srv.URL)
+
+	// registered before the watch takes its first look
+	watch := r.Watch(ctx)
+	if err := r.Register(ctx, "http://localhost:8001"); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case e := <-watch:
+		if e != (Event{Address: "http://localhost:8001", Type: PUT}) {
+			t.Errorf("event = %+v, want put of 8001", e)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("a node registered before the first query is missed")
+	}
+}
@@ -3,24 +3,41 @@ package registry
 import (
 	"context"
 	"fmt"
+	"io"
+	"log"
+	"maps"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"go.etcd.io/etcd/api/v3/mvccpb"
 	etcdv3 "go.etcd.io/etcd/client/v3"
 )
 
+// etcd registers nodes as keys attached to a lease kept alive while it
+// runs. If the lease is lost anyway, e.g. it expired during a partition,
+// a new one is granted and the nodes are published again.
 type etcd struct {
 	mu sync.Mutex
 
-	kv        etcdv3.KV
-	watcher   etcdv3.Watcher
-	grantid   etcdv3.LeaseID
-	endpoints []string
-	prefix    string
+	ctx     context.Context // of the registry, stops the keepalive
+	cancel  context.CancelFunc
+	client  io.Closer // of kv, watcher and lease
+	kv      etcdv3.KV
+	watcher etcdv3.Watcher
+	lease   etcdv3.Lease
+	grantid etcdv3.LeaseID
+	nodes   map[string]Node // addr -> the registered nodes
+	healthy atomic.Bool     // the lease is alive
+
+	prefix string
 }
 
-func New(ctx context.Context, prefix string, endpoints []string) (Client, error) {
+// New connects to the etcd cluster at endpoints and returns the registry of
+// the nodes under prefix. The lease of the nodes is kept alive until ctx is
+// done or the registry is closed.
+func New(ctx context.Context, prefix string, endpoints []string) (LeaseClient, error) {
 	client, err := etcdv3.New(etcdv3.Config{
 		Endpoints:   endpoints,
 		DialTimeout: 5 * time.Second,
@@ -28,72 +45,298 @@ func New(ctx context.Context, prefix string, endpoints []string) (Client, error)
 	if err != nil {
 		return nil, err
 	}
-
-	lease := etcdv3.NewLease(client)
-	grant, err := lease.Grant(ctx, keepAliveTTL)
+	r, err := newEtcd(ctx, prefix, client, client.KV, client.Watcher, client.Lease)
 	if err != nil {
 		return nil, err
 	}
+	return r, nil
+}
 
-	ch, err := lease.KeepAlive(ctx, grant.ID)
+// newEtcd returns the registry on the kv, watcher and lease of client
+func newEtcd(ctx context.Context, prefix string, client io.Closer, kv etcdv3.KV, watcher etcdv3.Watcher, lease etcdv3.Lease) (*etcd, error) {
+	r := &etcd{
+		client:  client,
+		kv:      kv,
+		watcher: watcher,
+		lease:   lease,
+		nodes:   make(map[string]Node),
+		prefix:  prefix,
+	}
+	r.ctx, r.cancel = context.WithCancel(ctx)
+	id, ch, err := r.grant(ctx)
 	if err != nil {
+		r.cancel()
+		client.Close()
 		return nil, err
 	}
+	r.grantid = id
+	r.healthy.Store(true)
+	go r.keepAlive(ch)
+	return r, nil
+}
 
-	go func() {
+// grant a new lease and keep it alive until r.ctx is done
+func (r *etcd) grant(ctx context.Context) (etcdv3.LeaseID, <-chan *etcdv3.LeaseKeepAliveResponse, error) {
+	grant, err := r.lease.Grant(ctx, keepAliveTTL)
+	if err != nil {
+		return 0, nil, err
+	}
+	ch, err := r.lease.KeepAlive(r.ctx, grant.ID)
+	if err != nil {
+		return 0, nil, err
+	}
+	return grant.ID, ch, nil
+}
+
+// keepAlive drains the keepalive responses of the lease. The channel is
+// closed when the lease is lost, then the nodes are registered again with a
+// new lease, retrying until it succeeds or r.ctx is done.
+func (r *etcd) keepAlive(ch <-chan *etcdv3.LeaseKeepAliveResponse) {
+	for {
 		for range ch {
 		}
-	}()
+		if r.ctx.Err() != nil {
+			return
+		}
+		r.healthy.Store(false)
+		log.Println("[cb-cache] etcd lease lost, registering the nodes again")
+
+		for {
+			select {
+			case <-r.ctx.Done():
+				return
+			case <-time.After(retryInterval):
+			}
+			var err error
+			if ch, err = r.recover(); err == nil {
+				break
+			}
+			log.Println("[cb-cache] etcd lease not recovered:", err)
+		}
+		r.healthy.Store(true)
+	}
+}
+
+// recover grants a new lease and puts the registered nodes with it.
+// r.mu is only held to switch the lease, not during the requests.
+func (r *etcd) recover() (<-chan *etcdv3.LeaseKeepAliveResponse, error) {
+	ctx, cancel := context.WithTimeout(r.ctx, keepAliveTTL*time.Second)
+	defer cancel()
+	id, ch, err := r.grant(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// nodes registered from now on use the new lease
+	r.mu.Lock()
+	r.grantid = id
+	nodes := maps.Clone(r.nodes)
+	r.mu.Unlock()
+
+	for _, node := range nodes {
+		if err := r.put(ctx, id, node); err != nil {
+			// don't keep the lease alive until the next attempt
+			r.lease.Revoke(ctx, id)
+			return nil, err
+		}
+	}
+
+	// a node deregistered meanwhile may have been put again
+	r.mu.Lock()
+	var gone []string
+	for addr := range nodes {
+		if _, ok := r.nodes[addr]; !ok {
+			gone = append(gone, addr)
+		}
+	}
+	r.mu.Unlock()
+	for _, addr := range gone {
+		r.kv.Delete(ctx, r.key(addr))
+	}
+	return ch, nil
+}
 
-	return &etcd{
-		kv:        etcdv3.NewKV(client),
-		watcher:   etcdv3.NewWatcher(client),
-		grantid:   grant.ID,
-		endpoints: endpoints,
-		prefix:    prefix,
-	}, nil
+// Healthy reports whether the lease of the registered nodes is alive,
+// it is not while it is being recovered
+func (r *etcd) Healthy() bool {
+	return r.healthy.Load()
+}
+
+// Close revokes the lease, which removes the registered nodes at once
+// instead of after keepAliveTTL, and closes the client
+func (r *etcd) Close() error {
+	r.cancel() // no more recovery
+	r.healthy.Store(false)
+	r.mu.Lock()
+	id := r.grantid
+	r.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), keepAliveTTL*time.Second)
+	defer cancel()
+	_, err := r.lease.Revoke(ctx, id)
+	if cerr := r.client.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func (r *etcd) key(addr string) string {
+	return fmt.Sprintf("%s%s", r.prefix, addr)
+}
+
+// put node with lease id
+func (r *etcd) put(ctx context.Context, id etcdv3.LeaseID, node Node) error {
+	_, err := r.kv.Put(ctx, r.key(node.Addr), node.Encode(), etcdv3.WithLease(id))
+	return err
 }
 
 // Register a node and start goroutine to keep lease
 func (r *etcd) Register(ctx context.Context, addr string) error {
-	key := fmt.Sprintf("%s%s", r.prefix, addr)
-	_, err := r.kv.Put(ctx, key, addr, etcdv3.WithLease(r.grantid))
-	return err
+	return r.RegisterNode(ctx, ParseNode(addr))
+}
+
+// RegisterNode publishes node under its address
+func (r *etcd) RegisterNode(ctx context.Context, node Node) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if err := r.put(ctx, r.grantid, node); err != nil {
+		return err
+	}
+	r.nodes[node.Addr] = node
+	return nil
 }
 
 func (r *etcd) Deregister(ctx context.Context, addr string) error {
-	key := fmt.Sprintf("%s%s", r.prefix, addr)
-	_, err := r.kv.Delete(ctx, key, etcdv3.WithLease(r.grantid))
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	addr = ParseNode(addr).Addr
+	delete(r.nodes, addr)
+	_, err := r.kv.Delete(ctx, r.key(addr))
 	return err
 }
 
 // GetAddress get all active node's address
 func (r *etcd) GetAddress(ctx context.Context) ([]string, error) {
+	nodes, err := r.GetNodes(ctx)
+	return addresses(nodes), err
+}
+
+// GetNodes get all active nodes
+func (r *etcd) GetNodes(ctx context.Context) ([]Node, error) {
 	resp, err := r.kv.Get(ctx, r.prefix, etcdv3.WithPrefix())
 	if err != nil {
 		return nil, err
 	}
-	addrs := make([]string, len(resp.Kvs))
+	nodes := make([]Node, len(resp.Kvs))
 	for i, kv := range resp.Kvs {
-		addrs[i] = string(kv.Value)
+		nodes[i] = ParseNode(string(kv.Value))
 	}
-	return addrs, nil
+	return nodes, nil
 }
 
 // Watch etcd event
 // case 1: maybe some nodes are broken, then other clients will delete it
 // case 2: some nodes are added, then other clients will add it
 func (r *etcd) Watch(ctx context.Context) <-chan Event {
+	return watchAddresses(ctx, r.WatchNodes(ctx))
+}
+
+// WatchNodes sends the nodes put and removed under the prefix
+func (r *etcd) WatchNodes(ctx context.Context) <-chan NodeEvent {
 	watchChan := r.watcher.Watch(ctx, r.prefix, etcdv3.WithPrefix())
-	ch := make(chan Event, eventChanSize)
+	ch := make(chan NodeEvent, eventChanSize)
 	go func() {
+		defer close(ch)
 		for watchRsp := range watchChan {
 			for _, event := range watchRsp.Events {
+				var e NodeEvent
 				switch event.Type {
 				case mvccpb.PUT:
-					ch <- Event{Address: string(event.Kv.Value), Type: PUT}
+					e = NodeEvent{Node: ParseNode(string(event.Kv.Value)), Type: PUT}
 				case mvccpb.DELETE:
-					ch <- Event{Address: string(event.Kv.Key[len(r.prefix):]), Type: REMOVE}
+					// nodes registered before the descriptors keep their zone in the key
+					e = NodeEvent{Node: ParseNode(string(event.Kv.Key[len(r.prefix):])), Type: REMOVE}
+				default:
+					continue
+				}
+				if !send(ctx, ch, []NodeEvent{e}) {
+					return
+				}
+			}
+		}
+	}()
+	return ch
+}
+
+var _ Store = &etcd{}
+
+// metaKey is outside the prefix of nodes, so values aren't watched as nodes
+func (r *etcd) metaKey(name string) string {
+	return fmt.Sprintf("%s.meta/%s", strings.TrimSuffix(r.prefix, "/"), name)
+}
+
+// Load a value saved without lease
+func (r *etcd) Load(ctx context.Context, name string) ([]byte, error) {
+	resp, err := r.kv.Get(ctx, r.metaKey(name))
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.Kvs) == 0 {
+		return nil, nil
+	}
+	return resp.Kvs[0].Value, nil
+}
+
+// Save a value without lease, it outlives the node saving it
+func (r *etcd) Save(ctx context.Context, name string, value []byte) error {
+	_, err := r.kv.Put(ctx, r.metaKey(name), string(value))
+	return err
+}
+
+// Create a value without lease unless the key exists, in a transaction
+func (r *etcd) Create(ctx context.Context, name string, value []byte) ([]byte, error) {
+	key := r.metaKey(name)
+	resp, err := r.kv.Txn(ctx).
+		If(etcdv3.Compare(etcdv3.CreateRevision(key), "=", 0)).
+		Then(etcdv3.OpPut(key, string(value))).
+		Else(etcdv3.OpGet(key)).
+		Commit()
+	if err != nil {
+		return nil, err
+	}
+	if resp.Succeeded {
+		return value, nil
+	}
+	kvs := resp.Responses[0].GetResponseRange().GetKvs()
+	if len(kvs) == 0 { /*deleted meanwhile*/
+		return nil, fmt.Errorf("[cb-cache] %s deleted while created", key)
+	}
+	return kvs[0].Value, nil
+}
+
+// CompareAndSwap a value without lease in a transaction on the old value
+func (r *etcd) CompareAndSwap(ctx context.Context, name string, old, value []byte) (bool, error) {
+	key := r.metaKey(name)
+	resp, err := r.kv.Txn(ctx).
+		If(etcdv3.Compare(etcdv3.Value(key), "=", string(old))).
+		Then(etcdv3.OpPut(key, string(value))).
+		Commit()
+	if err != nil {
+		return false, err
+	}
+	return resp.Succeeded, nil
+}
+
+func (r *etcd) WatchValue(ctx context.Context, name string) <-chan []byte {
+	watchChan := r.watcher.Watch(ctx, r.metaKey(name))
+	ch := make(chan []byte, eventChanSize)
+	go func() {
+		for watchRsp := range watchChan {
+			for _, event := range watchRsp.Events {
+				if event.Type == mvccpb.PUT {
+					ch <- event.Kv.Value
 				}
 			}
 		}
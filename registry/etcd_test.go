@@ -0,0 +1,160 @@
+package registry
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	etcdv3 "go.etcd.io/etcd/client/v3"
+)
+
+// fakeKV keeps the keys in memory, all under the single lease of fakeLease
+type fakeKV struct {
+	etcdv3.KV
+	mu   sync.Mutex
+	keys map[string]string
+}
+
+func (kv *fakeKV) Put(ctx context.Context, key, val string, opts ...etcdv3.OpOption) (*etcdv3.PutResponse, error) {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	kv.keys[key] = val
+	return &etcdv3.PutResponse{}, nil
+}
+
+func (kv *fakeKV) Delete(ctx context.Context, key string, opts ...etcdv3.OpOption) (*etcdv3.DeleteResponse, error) {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	delete(kv.keys, key)
+	return &etcdv3.DeleteResponse{}, nil
+}
+
+func (kv *fakeKV) clear() {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	kv.keys = make(map[string]string)
+}
+
+func (kv *fakeKV) has(key string) bool {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	_, ok := kv.keys[key]
+	return ok
+}
+
+type fakeLease struct {
+	etcdv3.Lease
+	kv *fakeKV
+
+	mu        sync.Mutex
+	id        etcdv3.LeaseID // of the last grant
+	failGrant int            // number of grants to fail
+	lost      chan struct{}  // closed when the lease of id is lost
+	revoked   []etcdv3.LeaseID
+}
+
+func (l *fakeLease) Grant(ctx context.Context, ttl int64) (*etcdv3.LeaseGrantResponse, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.failGrant > 0 {
+		l.failGrant--
+		return nil, errors.New("no leader")
+	}
+	l.id++
+	return &etcdv3.LeaseGrantResponse{ID: l.id}, nil
+}
+
+func (l *fakeLease) KeepAlive(ctx context.Context, id etcdv3.LeaseID) (<-chan *etcdv3.LeaseKeepAliveResponse, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	lost := make(chan struct{})
+	l.lost = lost
+	ch := make(chan *etcdv3.LeaseKeepAliveResponse)
+	go func() {
+		defer close(ch)
+		select {
+		case <-ctx.Done():
+		case <-lost:
+		}
+	}()
+	return ch, nil
+}
+
+func (l *fakeLease) Revoke(ctx context.Context, id etcdv3.LeaseID) (*etcdv3.LeaseRevokeResponse, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.revoked = append(l.revoked, id)
+	l.kv.clear()
+	return &etcdv3.LeaseRevokeResponse{}, nil
+}
+
+// lose the lease as if it expired, its keys go with it
+func (l *fakeLease) lose() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	close(l.lost)
+	l.kv.clear()
+}
+
+type fakeCloser struct {
+	closed bool
+}
+
+func (c *fakeCloser) Close() error {
+	c.closed = true
+	return nil
+}
+
+func TestEtcd_LeaseLost(t *testing.T) {
+	kv := &fakeKV{keys: make(map[string]string)}
+	lease := &fakeLease{kv: kv}
+	client := &fakeCloser{}
+	r, err := newEtcd(context.Background(), "_cb-cache/", client, kv, nil, lease)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Register(context.Background(), "http://localhost:8001"); err != nil {
+		t.Fatal(err)
+	}
+	if !r.Healthy() {
+		t.Error("unhealthy with the lease alive")
+	}
+
+	// the first grant of a new lease fails and is retried
+	lease.mu.Lock()
+	lease.failGrant = 1
+	lease.mu.Unlock()
+	lease.lose()
+	deadline := time.Now().Add(time.Second)
+	for r.Healthy() {
+		if time.Now().After(deadline) {
+			t.Fatal("healthy after the lease is lost")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	deadline = time.Now().Add(3 * time.Second)
+	for !r.Healthy() || !kv.has("_cb-cache/http://localhost:8001") {
+		if time.Now().After(deadline) {
+			t.Fatal("the node is not registered again")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	lease.mu.Lock()
+	defer lease.mu.Unlock()
+	if len(lease.revoked) != 1 || lease.revoked[0] != lease.id {
+		t.Errorf("revoked %v, want the lease %d", lease.revoked, lease.id)
+	}
+	if !client.closed || r.Healthy() {
+		t.Error("the client is not closed or healthy after Close")
+	}
+	if kv.has("_cb-cache/http://localhost:8001") {
+		t.Error("the node is still there after Close")
+	}
+}
@@ -0,0 +1,158 @@
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+)
+
+const zoneParam = "zone"
+
+// Node describes a registered cache node, it is published as JSON
+type Node struct {
+	Addr string `json:"addr"` // e.g. http://10.0.0.1:8001
+	Zone string `json:"zone,omitempty"`
+	// share of the keys relative to the other nodes, 0 counts as 1
+	Weight  int    `json:"weight,omitempty"`
+	Version string `json:"version,omitempty"` // build version
+	// supported peer protocols, e.g. http, and payload encodings, e.g. proto
+	Transports  []string  `json:"transports,omitempty"`
+	Serializers []string  `json:"serializers,omitempty"`
+	Started     time.Time `json:"started,omitempty"`
+}
+
+// ParseNode decodes a published node, which may also be an address only,
+// optionally labeled with a zone as in "http://10.0.0.1:8001?zone=az-1"
+func ParseNode(value string) Node {
+	if strings.HasPrefix(value, "{") {
+		var n Node
+		if err := json.Unmarshal([]byte(value), &n); err == nil && n.Addr != "" {
+			return n
+		}
+	}
+
+	i := strings.LastIndex(value, "?")
+	if i < 0 {
+		return Node{Addr: value}
+	}
+	q, err := url.ParseQuery(value[i+1:])
+	if err != nil {
+		return Node{Addr: value}
+	}
+	return Node{Addr: value[:i], Zone: q.Get(zoneParam)}
+}
+
+// String returns the address of n labeled with its zone, the form of the
+// nodes of Discovery
+func (n Node) String() string {
+	if n.Zone == "" {
+		return n.Addr
+	}
+	return n.Addr + "?" + zoneParam + "=" + url.QueryEscape(n.Zone)
+}
+
+// Encode n to the published JSON
+func (n Node) Encode() string {
+	bs, _ := json.Marshal(n) // can't fail
+	return string(bs)
+}
+
+func (n Node) equal(o Node) bool {
+	return n.Addr == o.Addr && n.Zone == o.Zone && n.Weight == o.Weight && n.Version == o.Version &&
+		slices.Equal(n.Transports, o.Transports) && slices.Equal(n.Serializers, o.Serializers) &&
+		n.Started.Equal(o.Started)
+}
+
+// NodeEvent is a change of the registered nodes, a removed node carries
+// only its address
+type NodeEvent struct {
+	Node Node
+	Type EventType
+}
+
+// NodeClient publishes and discovers node descriptors instead of addresses
+type NodeClient interface {
+	Client
+	RegisterNode(ctx context.Context, node Node) error
+	GetNodes(ctx context.Context) ([]Node, error)
+	WatchNodes(ctx context.Context) <-chan NodeEvent
+}
+
+// Nodes returns c if it is a NodeClient, otherwise c adapted to one
+// publishing the address and the zone of nodes only
+func Nodes(c Client) NodeClient {
+	if nc, ok := c.(NodeClient); ok {
+		return nc
+	}
+	return addrClient{c}
+}
+
+type addrClient struct {
+	Client
+}
+
+func (c addrClient) RegisterNode(ctx context.Context, node Node) error {
+	return c.Register(ctx, node.String())
+}
+
+func (c addrClient) GetNodes(ctx context.Context) ([]Node, error) {
+	addrs, err := c.GetAddress(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return parseNodes(addrs), nil
+}
+
+func (c addrClient) WatchNodes(ctx context.Context) <-chan NodeEvent {
+	events := c.Watch(ctx)
+	ch := make(chan NodeEvent, eventChanSize)
+	go func() {
+		defer close(ch)
+		for e := range events {
+			if !send(ctx, ch, []NodeEvent{{Node: ParseNode(e.Address), Type: e.Type}}) {
+				return
+			}
+		}
+	}()
+	return ch
+}
+
+func parseNodes(values []string) []Node {
+	nodes := make([]Node, len(values))
+	for i, v := range values {
+		nodes[i] = ParseNode(v)
+	}
+	return nodes
+}
+
+// addresses of nodes as returned by GetAddress
+func addresses(nodes []Node) []string {
+	addrs := make([]string, len(nodes))
+	for i, n := range nodes {
+		addrs[i] = n.String()
+	}
+	return addrs
+}
+
+// watchAddresses turns the node events into the events of Watch
+func watchAddresses(ctx context.Context, events <-chan NodeEvent) <-chan Event {
+	ch := make(chan Event, eventChanSize)
+	go func() {
+		defer close(ch)
+		for e := range events {
+			if !send(ctx, ch, []Event{{Address: e.Node.String(), Type: e.Type}}) {
+				return
+			}
+		}
+	}()
+	return ch
+}
+
+var (
+	_ NodeClient = &etcd{}
+	_ NodeClient = &consul{}
+	_ NodeClient = &zookeeper{}
+)
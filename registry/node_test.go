@@ -0,0 +1,62 @@
+package registry
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseNode(t *testing.T) {
+	node := Node{
+		Addr:        "http://10.0.0.1:8001",
+		Zone:        "az-1",
+		Weight:      2,
+		Version:     "v1.2.0",
+		Transports:  []string{"http"},
+		Serializers: []string{"proto"},
+		Started:     time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+	}
+	testCases := map[string]Node{
+		node.Encode():                     node,
+		"http://10.0.0.1:8001":            {Addr: "http://10.0.0.1:8001"},
+		"http://10.0.0.1:8001?zone=az-1":  {Addr: "http://10.0.0.1:8001", Zone: "az-1"},
+		"http://10.0.0.1:8001?zone=a%20b": {Addr: "http://10.0.0.1:8001", Zone: "a b"},
+		`{"zone":"az-1"}`:                 {Addr: `{"zone":"az-1"}`},
+	}
+	for value, want := range testCases {
+		if got := ParseNode(value); !got.equal(want) {
+			t.Errorf("ParseNode(%s) = %+v, want %+v", value, got, want)
+		}
+	}
+
+	if s := node.String(); s != "http://10.0.0.1:8001?zone=az-1" {
+		t.Errorf("String() = %s", s)
+	}
+}
+
+func TestDiff(t *testing.T) {
+	a := Node{Addr: "http://a:8001"}
+	b := Node{Addr: "http://b:8001", Weight: 1}
+	heavier := Node{Addr: "http://b:8001", Weight: 2}
+	c := Node{Addr: "http://c:8001"}
+
+	events := diff(
+		map[string]Node{a.Addr: a, b.Addr: b},
+		map[string]Node{b.Addr: heavier, c.Addr: c},
+	)
+	got := make(map[string]EventType)
+	for _, e := range events {
+		got[e.Node.Addr] = e.Type
+		if e.Node.Addr == b.Addr && e.Node.Weight != 2 {
+			t.Errorf("b is put with weight %d, want 2", e.Node.Weight)
+		}
+	}
+	want := map[string]EventType{a.Addr: REMOVE, b.Addr: PUT, c.Addr: PUT}
+	if len(got) != len(want) || len(events) != len(want) {
+		t.Fatalf("events = %+v", events)
+	}
+	for addr, typ := range want {
+		if got[addr] != typ {
+			t.Errorf("event of %s = %s, want %s", addr, got[addr], typ)
+		}
+	}
+}
@@ -1,12 +1,18 @@
 package registry
 
-import "context"
+import (
+	"context"
+	"io"
+	"time"
+)
 
 const (
 	// 续约间隔，单位秒
 	keepAliveTTL = 10
 	// 事件通道缓冲区大小
 	eventChanSize = 10
+	// 查询失败后的重试间隔
+	retryInterval = time.Second
 )
 
 type Client interface {
@@ -24,6 +30,39 @@ type Discovery interface {
 	Watch(ctx context.Context) <-chan Event
 }
 
+// Health is implemented by the registries whose nodes may vanish while
+// they run, e.g. with a lost lease, and are published again
+type Health interface {
+	// Healthy reports whether the registered nodes are published
+	Healthy() bool
+}
+
+// LeaseClient is a Client whose nodes live by a lease, as of New. A lost
+// lease is recovered, Healthy is false meanwhile. Close revokes the lease,
+// which removes the registered nodes at once.
+type LeaseClient interface {
+	NodeClient
+	Store
+	Health
+	io.Closer
+}
+
+// Store keeps small cluster-wide values next to the registered nodes,
+// e.g. the hash slot table
+type Store interface {
+	// Load returns nil if name has never been saved
+	Load(ctx context.Context, name string) ([]byte, error)
+	Save(ctx context.Context, name string, value []byte) error
+	// Create saves value unless name has been saved before, atomically, and
+	// returns the value saved now, the one of the first to create it
+	Create(ctx context.Context, name string, value []byte) ([]byte, error)
+	// CompareAndSwap saves value if name still holds old, atomically, and
+	// reports whether it did
+	CompareAndSwap(ctx context.Context, name string, old, value []byte) (bool, error)
+	// WatchValue sends every new value of name
+	WatchValue(ctx context.Context, name string) <-chan []byte
+}
+
 // Event 服务变化事件
 type Event struct {
 	Address string
@@ -36,3 +75,32 @@ const (
 	REMOVE = "remove"
 	PUT    = "put"
 )
+
+// diff returns the events turning the nodes known into current, both by address.
+// A node published again with another descriptor is put again.
+func diff(known, current map[string]Node) []NodeEvent {
+	var events []NodeEvent
+	for addr, n := range current {
+		if k, ok := known[addr]; !ok || !k.equal(n) {
+			events = append(events, NodeEvent{Node: n, Type: PUT})
+		}
+	}
+	for addr, n := range known {
+		if _, ok := current[addr]; !ok {
+			events = append(events, NodeEvent{Node: n, Type: REMOVE})
+		}
+	}
+	return events
+}
+
+// send events to ch, false if ctx is done
+func send[E any](ctx context.Context, ch chan<- E, events []E) bool {
+	for _, e := range events {
+		select {
+		case <-ctx.Done():
+			return false
+		case ch <- e:
+		}
+	}
+	return true
+}
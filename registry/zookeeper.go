@@ -1,3 +1,291 @@
 package registry
 
-// TODO zookeeper registry and discovery
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-zookeeper/zk"
+)
+
+// zookeeper registers nodes as ephemeral sequential znodes under root,
+// they vanish with the session of the node
+type zookeeper struct {
+	mu sync.Mutex
+
+	conn  zkConn
+	root  string            // e.g. /cb-cache/nodes
+	nodes map[string]zkNode // addr -> the registered nodes
+}
+
+// zkConn is the part of *zk.Conn used by the registry
+type zkConn interface {
+	Create(path string, data []byte, flags int32, acl []zk.ACL) (string, error)
+	CreateProtectedEphemeralSequential(path string, data []byte, acl []zk.ACL) (string, error)
+	Delete(path string, version int32) error
+	Exists(path string) (bool, *zk.Stat, error)
+	Get(path string) ([]byte, *zk.Stat, error)
+	Children(path string) ([]string, *zk.Stat, error)
+	ChildrenW(path string) ([]string, *zk.Stat, <-chan zk.Event, error)
+	Close()
+}
+
+type zkNode struct {
+	node Node
+	path string // of the znode, "" while it has none
+}
+
+// NewZookeeper connects to servers and returns the registry of the nodes
+// under root. The connection is closed when ctx is done, which removes
+// the registered nodes at once.
+func NewZookeeper(ctx context.Context, root string, servers []string) (Client, error) {
+	conn, events, err := zk.Connect(servers, keepAliveTTL*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	r, err := newZookeeper(ctx, root, conn, events)
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// newZookeeper returns the registry on conn, events are the session events of conn
+func newZookeeper(ctx context.Context, root string, conn zkConn, events <-chan zk.Event) (*zookeeper, error) {
+	r := &zookeeper{
+		conn:  conn,
+		root:  "/" + strings.Trim(root, "/"),
+		nodes: make(map[string]zkNode),
+	}
+	if err := r.ensureRoot(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+	go r.session(events)
+	return r, nil
+}
+
+// ensureRoot creates the persistent znodes of root
+func (r *zookeeper) ensureRoot() error {
+	path := ""
+	for _, name := range strings.Split(strings.TrimPrefix(r.root, "/"), "/") {
+		path += "/" + name
+		_, err := r.conn.Create(path, nil, 0, zk.WorldACL(zk.PermAll))
+		if err != nil && !errors.Is(err, zk.ErrNodeExists) {
+			return err
+		}
+	}
+	return nil
+}
+
+// session registers the nodes again when the session expired, the
+// ephemeral znodes have gone with it. Failures are retried until they succeed
+// or the connection is closed.
+func (r *zookeeper) session(events <-chan zk.Event) {
+	var retry <-chan time.Time
+	for {
+		select {
+		case e, ok := <-events:
+			if !ok {
+				return
+			}
+			if e.Type != zk.EventSession {
+				continue
+			}
+			switch e.State {
+			case zk.StateExpired:
+				r.expire()
+			case zk.StateHasSession:
+				retry = nil
+				if !r.reregister() {
+					retry = time.After(retryInterval)
+				}
+			}
+		case <-retry:
+			retry = nil
+			if !r.reregister() {
+				retry = time.After(retryInterval)
+			}
+		}
+	}
+}
+
+// expire marks the znodes of all nodes gone
+func (r *zookeeper) expire() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for addr, n := range r.nodes {
+		r.nodes[addr] = zkNode{node: n.node}
+	}
+}
+
+// reregister creates the znodes of the nodes which have none,
+// false if some failed
+func (r *zookeeper) reregister() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	ok := true
+	for addr, n := range r.nodes {
+		if n.path != "" {
+			continue
+		}
+		path, err := r.create(n.node)
+		if err != nil {
+			ok = false
+			continue
+		}
+		r.nodes[addr] = zkNode{node: n.node, path: path}
+	}
+	return ok
+}
+
+func (r *zookeeper) create(node Node) (string, error) {
+	return r.conn.CreateProtectedEphemeralSequential(r.root+"/node-", []byte(node.Encode()), zk.WorldACL(zk.PermAll))
+}
+
+// Register addr as an ephemeral sequential znode
+func (r *zookeeper) Register(ctx context.Context, addr string) error {
+	return r.RegisterNode(ctx, ParseNode(addr))
+}
+
+// RegisterNode publishes node as the data of an ephemeral sequential znode,
+// a node registered before with another descriptor is replaced
+func (r *zookeeper) RegisterNode(ctx context.Context, node Node) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	old, ok := r.nodes[node.Addr]
+	if ok && old.node.equal(node) && old.path != "" {
+		// the znode may have gone with a session
+		exists, _, err := r.conn.Exists(old.path)
+		if err != nil {
+			return err
+		}
+		if exists {
+			return nil
+		}
+	}
+	path, err := r.create(node)
+	if err != nil {
+		return err
+	}
+	r.nodes[node.Addr] = zkNode{node: node, path: path}
+	if ok && old.path != "" {
+		r.conn.Delete(old.path, -1) // gone with the session otherwise
+	}
+	return nil
+}
+
+func (r *zookeeper) Deregister(ctx context.Context, addr string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	addr = ParseNode(addr).Addr
+	n, ok := r.nodes[addr]
+	if !ok {
+		return nil
+	}
+	delete(r.nodes, addr)
+	if n.path == "" {
+		return nil
+	}
+	if err := r.conn.Delete(n.path, -1); err != nil && !errors.Is(err, zk.ErrNoNode) {
+		return err
+	}
+	return nil
+}
+
+// nodes of the znodes children of root, by address
+func (r *zookeeper) children(children []string) (map[string]Node, error) {
+	nodes := make(map[string]Node, len(children))
+	for _, child := range children {
+		data, _, err := r.conn.Get(r.root + "/" + child)
+		if errors.Is(err, zk.ErrNoNode) { /*gone meanwhile*/
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		n := ParseNode(string(data))
+		nodes[n.Addr] = n
+	}
+	return nodes, nil
+}
+
+// GetAddress get all registered node's address
+func (r *zookeeper) GetAddress(ctx context.Context) ([]string, error) {
+	nodes, err := r.GetNodes(ctx)
+	return addresses(nodes), err
+}
+
+// GetNodes get all registered nodes
+func (r *zookeeper) GetNodes(ctx context.Context) ([]Node, error) {
+	children, _, err := r.conn.Children(r.root)
+	if err != nil {
+		return nil, err
+	}
+	set, err := r.children(children)
+	if err != nil {
+		return nil, err
+	}
+	nodes := make([]Node, 0, len(set))
+	for _, n := range set {
+		nodes = append(nodes, n)
+	}
+	return nodes, nil
+}
+
+// Watch the addresses of the registered nodes
+func (r *zookeeper) Watch(ctx context.Context) <-chan Event {
+	return watchAddresses(ctx, r.WatchNodes(ctx))
+}
+
+// WatchNodes watches the children of root and sends the differences,
+// starting with the nodes there as puts, so nothing changed between a
+// GetNodes and the watch is missed
+func (r *zookeeper) WatchNodes(ctx context.Context) <-chan NodeEvent {
+	ch := make(chan NodeEvent, eventChanSize)
+	go func() {
+		defer close(ch)
+
+		known := map[string]Node{}
+		for ctx.Err() == nil {
+			children, _, watch, err := r.conn.ChildrenW(r.root)
+			var current map[string]Node
+			if err == nil {
+				current, err = r.children(children)
+			}
+			if err != nil {
+				if errors.Is(err, zk.ErrClosing) || errors.Is(err, zk.ErrConnectionClosed) {
+					return
+				}
+				select {
+				case <-ctx.Done():
+				case <-time.After(retryInterval):
+				}
+				continue
+			}
+
+			if !send(ctx, ch, diff(known, current)) {
+				return
+			}
+			known = current
+
+			// fires once on any change of the children or the session
+			select {
+			case <-ctx.Done():
+			case <-watch:
+			}
+		}
+	}()
+	return ch
+}
@@ -0,0 +1,257 @@
+package registry
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-zookeeper/zk"
+)
+
+// fakeZk keeps the znodes in memory, all of them under a single parent
+type fakeZk struct {
+	mu         sync.Mutex
+	znodes     map[string][]byte
+	ephemeral  map[string]bool
+	seq        int
+	watches    []chan zk.Event // of the children
+	events     chan zk.Event   // of the session
+	failCreate int             // number of creations to fail
+	closed     bool
+}
+
+func newFakeZk() *fakeZk {
+	return &fakeZk{
+		znodes:    make(map[string][]byte),
+		ephemeral: make(map[string]bool),
+		events:    make(chan zk.Event, 16),
+	}
+}
+
+// changed fires the children watches, must be called with f.mu held
+func (f *fakeZk) changed() {
+	for _, w := range f.watches {
+		w <- zk.Event{Type: zk.EventNodeChildrenChanged}
+		close(w)
+	}
+	f.watches = nil
+}
+
+func (f *fakeZk) Create(path string, data []byte, flags int32, acl []zk.ACL) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.znodes[path]; ok {
+		return "", zk.ErrNodeExists
+	}
+	f.znodes[path] = data
+	return path, nil
+}
+
+func (f *fakeZk) CreateProtectedEphemeralSequential(path string, data []byte, acl []zk.ACL) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.closed {
+		return "", zk.ErrConnectionClosed
+	}
+	if f.failCreate > 0 {
+		f.failCreate--
+		return "", zk.ErrNoServer
+	}
+	f.seq++
+	path = fmt.Sprintf("%s%010d", path, f.seq)
+	f.znodes[path] = data
+	f.ephemeral[path] = true
+	f.changed()
+	return path, nil
+}
+
+func (f *fakeZk) Delete(path string, version int32) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.znodes[path]; !ok {
+		return zk.ErrNoNode
+	}
+	delete(f.znodes, path)
+	delete(f.ephemeral, path)
+	f.changed()
+	return nil
+}
+
+func (f *fakeZk) Exists(path string) (bool, *zk.Stat, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	_, ok := f.znodes[path]
+	return ok, &zk.Stat{}, nil
+}
+
+func (f *fakeZk) Get(path string) ([]byte, *zk.Stat, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	data, ok := f.znodes[path]
+	if !ok {
+		return nil, nil, zk.ErrNoNode
+	}
+	return data, &zk.Stat{}, nil
+}
+
+func (f *fakeZk) Children(path string) ([]string, *zk.Stat, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.children(path)
+}
+
+// children must be called with f.mu held
+func (f *fakeZk) children(path string) ([]string, *zk.Stat, error) {
+	if f.closed {
+		return nil, nil, zk.ErrConnectionClosed
+	}
+	var children []string
+	for p := range f.ephemeral {
+		if strings.HasPrefix(p, path+"/") {
+			children = append(children, strings.TrimPrefix(p, path+"/"))
+		}
+	}
+	return children, &zk.Stat{}, nil
+}
+
+func (f *fakeZk) ChildrenW(path string) ([]string, *zk.Stat, <-chan zk.Event, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	children, stat, err := f.children(path)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	w := make(chan zk.Event, 1)
+	f.watches = append(f.watches, w)
+	return children, stat, w, nil
+}
+
+func (f *fakeZk) Close() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if !f.closed {
+		f.closed = true
+		f.changed()
+		close(f.events)
+	}
+}
+
+// expireSession removes the ephemeral znodes as the server does
+func (f *fakeZk) expireSession() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for p := range f.ephemeral {
+		delete(f.znodes, p)
+	}
+	f.ephemeral = make(map[string]bool)
+	f.changed()
+	f.events <- zk.Event{Type: zk.EventSession, State: zk.StateExpired}
+}
+
+// published returns the data of the ephemeral znodes
+func (f *fakeZk) published() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var data []string
+	for p := range f.ephemeral {
+		data = append(data, ParseNode(string(f.znodes[p])).Addr)
+	}
+	sort.Strings(data)
+	return data
+}
+
+func waitPublished(t *testing.T, f *fakeZk, want string) {
+	t.Helper()
+	deadline := time.Now().Add(3 * time.Second)
+	for strings.Join(f.published(), ",") != want {
+		if time.Now().After(deadline) {
+			t.Fatalf("published %v, want %s", f.published(), want)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestZookeeper(t *testing.T) {
+	fake := newFakeZk()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	r, err := newZookeeper(ctx, "/cb-cache/nodes", fake, fake.events)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := fake.znodes["/cb-cache"]; !ok {
+		t.Error("the root is not created")
+	}
+
+	if err := r.Register(ctx, "http://localhost:8001"); err != nil {
+		t.Fatal(err)
+	}
+	watch := r.Watch(ctx)
+	next := func() Event {
+		select {
+		case e := <-watch:
+			return e
+		case <-time.After(2 * time.Second):
+			t.Fatal("no event")
+			return Event{}
+		}
+	}
+
+	if e := next(); e != (Event{Address: "http://localhost:8001", Type: PUT}) {
+		t.Errorf("event = %+v, want put of 8001", e)
+	}
+	if err := r.Register(ctx, "http://localhost:8002?zone=b"); err != nil {
+		t.Fatal(err)
+	}
+	if e := next(); e != (Event{Address: "http://localhost:8002?zone=b", Type: PUT}) {
+		t.Errorf("event = %+v, want put of 8002", e)
+	}
+	if err := r.Deregister(ctx, "http://localhost:8001"); err != nil {
+		t.Fatal(err)
+	}
+	if e := next(); e != (Event{Address: "http://localhost:8001", Type: REMOVE}) {
+		t.Errorf("event = %+v, want remove of 8001", e)
+	}
+	if addrs, err := r.GetAddress(ctx); err != nil || len(addrs) != 1 || addrs[0] != "http://localhost:8002?zone=b" {
+		t.Errorf("GetAddress = %v, %v", addrs, err)
+	}
+
+	// closing the connection with ctx ends the watch
+	cancel()
+	for range watch {
+	}
+}
+
+func TestZookeeper_SessionExpired(t *testing.T) {
+	fake := newFakeZk()
+	r, err := newZookeeper(context.Background(), "/cb-cache", fake, fake.events)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fake.Close()
+	if err := r.Register(context.Background(), "http://localhost:8001"); err != nil {
+		t.Fatal(err)
+	}
+
+	// the first attempt in the new session fails and is retried
+	fake.expireSession()
+	fake.mu.Lock()
+	fake.failCreate = 1
+	fake.mu.Unlock()
+	fake.events <- zk.Event{Type: zk.EventSession, State: zk.StateHasSession}
+	waitPublished(t, fake, "http://localhost:8001")
+
+	// a node whose znode has gone is created again by registering it again
+	r.mu.Lock()
+	path := r.nodes["http://localhost:8001"].path
+	r.mu.Unlock()
+	fake.Delete(path, -1)
+	if err := r.Register(context.Background(), "http://localhost:8001"); err != nil {
+		t.Fatal(err)
+	}
+	waitPublished(t, fake, "http://localhost:8001")
+}
@@ -0,0 +1,287 @@
+package cb_cache
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/cold-bin/cb-cache/consistencyhash"
+	"github.com/cold-bin/cb-cache/registry"
+)
+
+// memRegistry is a registry.Client kept in memory
+type memRegistry struct {
+	mu     sync.Mutex
+	addrs  map[string]bool
+	events chan registry.Event
+}
+
+func newMemRegistry(addrs ...string) *memRegistry {
+	r := &memRegistry{addrs: make(map[string]bool), events: make(chan registry.Event, 16)}
+	for _, addr := range addrs {
+		r.addrs[addr] = true
+	}
+	return r
+}
+
+func (r *memRegistry) Register(ctx context.Context, addr string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.addrs[addr] = true
+	return nil
+}
+
+func (r *memRegistry) Deregister(ctx context.Context, addr string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.addrs, addr)
+	return nil
+}
+
+func (r *memRegistry) GetAddress(ctx context.Context) ([]string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	addrs := make([]string, 0, len(r.addrs))
+	for addr := range r.addrs {
+		addrs = append(addrs, addr)
+	}
+	return addrs, nil
+}
+
+func (r *memRegistry) Watch(ctx context.Context) <-chan registry.Event {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.events
+}
+
+// breakWatch closes the watch, the next Watch gets a new one
+func (r *memRegistry) breakWatch() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	close(r.events)
+	r.events = make(chan registry.Event, 16)
+}
+
+func (r *memRegistry) has(addr string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.addrs[addr]
+}
+
+// put or remove addr and tell the watcher
+func (r *memRegistry) send(addr string, typ registry.EventType) {
+	if typ == registry.PUT {
+		r.Register(context.Background(), addr)
+	} else {
+		r.Deregister(context.Background(), addr)
+	}
+	r.mu.Lock()
+	events := r.events
+	r.mu.Unlock()
+	events <- registry.Event{Address: addr, Type: typ}
+}
+
+// waitPeers waits until pool knows n peers besides self
+func waitPeers(t *testing.T, pool *HTTPPool, n int) {
+	t.Helper()
+	deadline := time.Now().Add(3 * time.Second)
+	for {
+		pool.mu.Lock()
+		got := len(pool.httpGetters)
+		pool.mu.Unlock()
+		if got == n {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("pool knows %d peers, want %d", got, n)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestHTTPPool_Registry(t *testing.T) {
+	r := newMemRegistry("http://a:8001")
+	pool := NewHTTPPool("http://self:8001", 50, WithZone("z1"))
+	defer pool.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := pool.UseRegistry(ctx, r); err != nil {
+		t.Fatal(err)
+	}
+	if !r.has(ZonedPeer("http://self:8001", "z1")) {
+		t.Error("self is not registered with its zone")
+	}
+	waitPeers(t, pool, 1)
+
+	r.send("http://b:8001?zone=z2", registry.PUT)
+	waitPeers(t, pool, 2)
+	pool.mu.Lock()
+	z := pool.httpGetters["http://b:8001"].zone
+	pool.mu.Unlock()
+	if z != "z2" {
+		t.Errorf("zone of b = %q, want z2", z)
+	}
+
+	r.send("http://a:8001", registry.REMOVE)
+	waitPeers(t, pool, 1)
+}
+
+func TestHTTPPool_RegistryResync(t *testing.T) {
+	r := newMemRegistry("http://a:8001", "http://b:8001")
+	pool := NewHTTPPool("http://self:8001", 7)
+	defer pool.Close()
+
+	if err := pool.UseRegistry(context.Background(), r); err != nil {
+		t.Fatal(err)
+	}
+	waitPeers(t, pool, 2)
+	want := consistencyhash.NewMap(7)
+	want.Set("http://self:8001", "http://a:8001", "http://b:8001")
+	pool.mu.Lock()
+	for i := 0; i < 100; i++ {
+		if k := strconv.Itoa(i); pool.peers.Get(k) != want.Get(k) {
+			t.Errorf("peer of %s = %s, want %s of a ring with 7 replicas", k, pool.peers.Get(k), want.Get(k))
+			break
+		}
+	}
+	a := pool.httpGetters["http://a:8001"]
+	pool.mu.Unlock()
+
+	// changes while the watch is broken are only seen by a resync
+	r.breakWatch()
+	r.Deregister(context.Background(), "http://b:8001")
+	r.Register(context.Background(), "http://c:8001")
+	deadline := time.Now().Add(3 * time.Second)
+	for {
+		pool.mu.Lock()
+		_, b := pool.httpGetters["http://b:8001"]
+		_, c := pool.httpGetters["http://c:8001"]
+		keep := pool.httpGetters["http://a:8001"] == a
+		pool.mu.Unlock()
+		if !b && c {
+			if !keep {
+				t.Error("the getter of a peer still there is replaced")
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("peers are not resynced after the watch broke")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	r.send("http://d:8001", registry.PUT)
+	waitPeers(t, pool, 3)
+}
+
+func TestHTTPPool_RegistryClose(t *testing.T) {
+	r := newMemRegistry()
+	pool := NewHTTPPool("http://self:8001", 50)
+	if err := pool.UseRegistry(context.Background(), r); err != nil {
+		t.Fatal(err)
+	}
+	if !r.has("http://self:8001") {
+		t.Fatal("self is not registered")
+	}
+
+	pool.Close()
+	deadline := time.Now().Add(time.Second)
+	for r.has("http://self:8001") {
+		if time.Now().After(deadline) {
+			t.Fatal("self is still registered after Close")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// unreachableRegistry registers nodes but fails to list them
+type unreachableRegistry struct {
+	*memRegistry
+}
+
+func (r unreachableRegistry) GetAddress(ctx context.Context) ([]string, error) {
+	return nil, errors.New("unreachable")
+}
+
+func TestHTTPPool_RegistrySyncFailed(t *testing.T) {
+	r := unreachableRegistry{newMemRegistry()}
+	pool := NewHTTPPool("http://self:8001", 50)
+	defer pool.Close()
+	if err := pool.UseRegistry(context.Background(), r); err == nil {
+		t.Fatal("UseRegistry succeeded without the nodes")
+	}
+	if r.has("http://self:8001") {
+		t.Error("self is still registered, unsynced")
+	}
+}
+
+func TestHTTPPool_Nodes(t *testing.T) {
+	pool := NewHTTPPool("http://self:8001", 50, WithZone("z1"), WithVersion("v2"))
+	defer pool.Close()
+
+	pool.mu.Lock()
+	pool.resetPeers(
+		pool.node(),
+		registry.Node{Addr: "http://heavy:8001", Zone: "z2", Weight: 3, Version: "v2"},
+		registry.Node{Addr: "http://json:8001", Zone: "z1", Serializers: []string{"json"}},
+		registry.Node{Addr: "http://old:8001", Zone: "z1", Version: "v1"},
+		registry.Node{Addr: "http://new:8001", Zone: "z1", Version: "v2", Transports: []string{"http"}},
+	)
+	owned := make(map[string]int)
+	for i := 0; i < 10000; i++ {
+		owned[pool.peers.Get(strconv.Itoa(i))]++
+	}
+	_, jsonPeer := pool.httpGetters["http://json:8001"]
+	preferences := []int{
+		pool.preference(pool.httpGetters["http://new:8001"]),
+		pool.preference(pool.httpGetters["http://old:8001"]),
+		pool.preference(pool.httpGetters["http://heavy:8001"]),
+	}
+	pool.mu.Unlock()
+
+	if jsonPeer || owned["http://json:8001"] > 0 {
+		t.Error("a peer not speaking the serializer of self is on the ring")
+	}
+	if owned["http://heavy:8001"] < 2*owned["http://old:8001"] {
+		t.Errorf("the peer of weight 3 owns %d keys, the one of weight 1 %d", owned["http://heavy:8001"], owned["http://old:8001"])
+	}
+	if !(preferences[0] < preferences[1] && preferences[1] < preferences[2]) {
+		t.Errorf("preferences of same version, other version, other zone = %v", preferences)
+	}
+}
+
+// healthRegistry is a memRegistry telling its health
+type healthRegistry struct {
+	*memRegistry
+	healthy atomic.Bool
+}
+
+func (r *healthRegistry) Healthy() bool {
+	return r.healthy.Load()
+}
+
+func TestHTTPPool_RegistryHealthy(t *testing.T) {
+	pool := NewHTTPPool("http://self:8001", 50)
+	defer pool.Close()
+	if !pool.RegistryHealthy() {
+		t.Error("unhealthy without a registry")
+	}
+
+	r := &healthRegistry{memRegistry: newMemRegistry()}
+	r.healthy.Store(true)
+	if err := pool.UseRegistry(context.Background(), r); err != nil {
+		t.Fatal(err)
+	}
+	if !pool.RegistryHealthy() {
+		t.Error("unhealthy with a healthy registry")
+	}
+	r.healthy.Store(false)
+	if pool.RegistryHealthy() {
+		t.Error("healthy while the registry lost self")
+	}
+}
@@ -0,0 +1,55 @@
+package cb_cache
+
+import (
+	"hash/fnv"
+	"log"
+	"sort"
+	"sync/atomic"
+)
+
+// RingVersion identifies the membership known to the pool, it is the same on
+// all nodes agreeing on the peers. With hash slots it is the table version.
+func (c *HTTPPool) RingVersion() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.slots != nil {
+		return c.slots.Version
+	}
+	return c.ringVersion
+}
+
+// RingMismatches returns the number of peer requests sent with a ring version
+// different from this pool's, i.e. while the peers disagreed on membership
+func (c *HTTPPool) RingMismatches() uint64 {
+	return atomic.LoadUint64(&c.ringMismatches)
+}
+
+// updateRingVersion fingerprints the sorted peers.
+// must be called with c.mu held
+func (c *HTTPPool) updateRingVersion() {
+	nodes := make([]string, 0, len(c.httpGetters)+1)
+	nodes = append(nodes, c.self)
+	for addr := range c.httpGetters {
+		nodes = append(nodes, addr)
+	}
+	sort.Strings(nodes)
+
+	h := fnv.New64a()
+	for _, node := range nodes {
+		h.Write([]byte(node))
+		h.Write([]byte{0})
+	}
+	c.ringVersion = h.Sum64()
+}
+
+// checkRingVersion logs the first request of every disagreeing ring version
+func (c *HTTPPool) checkRingVersion(v uint64, from string) {
+	local := c.RingVersion()
+	if v == local {
+		return
+	}
+	atomic.AddUint64(&c.ringMismatches, 1)
+	if atomic.SwapUint64(&c.lastMismatch, v) != v {
+		log.Printf("[cb-cache] ring version %d of peer %s differs from %d, membership disagrees", v, from, local)
+	}
+}
@@ -0,0 +1,61 @@
+package cb_cache
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+// panicPicker fails the test if a routed request is forwarded again
+type panicPicker struct {
+	t *testing.T
+}
+
+func (p panicPicker) PickPeer(key string) (PeerGetter, bool) {
+	p.t.Errorf("PickPeer(%s) called for a routed request", key)
+	return nil, false
+}
+
+func TestHTTPPool_RoutedLoadsLocally(t *testing.T) {
+	g := NewGroup("routed", 1<<10, WithGetter(func(ctx context.Context, k string) ([]byte, error) {
+		return []byte("local " + k), nil
+	}))
+	g.PutPeers(panicPicker{t})
+
+	pool := NewHTTPPool("http://self", 50)
+	defer pool.Close()
+	r := httptest.NewRequest(http.MethodGet, DefaultBasePath+"routed/key", nil)
+	r.Header.Set(routedHeader, "1")
+	w := httptest.NewRecorder()
+	pool.ServeHTTP(w, r)
+	if w.Code != http.StatusOK || g.Stats.GetterFuncFrom != 1 {
+		t.Errorf("status %d, getter loads %d, want a local load", w.Code, g.Stats.GetterFuncFrom)
+	}
+}
+
+func TestHTTPPool_RingVersion(t *testing.T) {
+	a := NewHTTPPool("http://a", 50)
+	b := NewHTTPPool("http://b", 50)
+	a.Set("http://a", "http://b", "http://c")
+	b.Set("http://c", "http://b", "http://a")
+	if a.RingVersion() != b.RingVersion() {
+		t.Fatal("pools agreeing on peers have different ring versions")
+	}
+
+	// b hasn't seen c leave yet
+	a.Set("http://a", "http://b")
+	if a.RingVersion() == b.RingVersion() {
+		t.Fatal("pools disagreeing on peers have the same ring version")
+	}
+
+	NewGroup("ring-version", 1<<10)
+	r := httptest.NewRequest(http.MethodGet, DefaultBasePath+"ring-version/key", nil)
+	r.Header.Set(routedHeader, "1")
+	r.Header.Set(ringVersionHeader, strconv.FormatUint(a.RingVersion(), 10))
+	b.ServeHTTP(httptest.NewRecorder(), r)
+	if n := b.RingMismatches(); n != 1 {
+		t.Errorf("RingMismatches = %d, want 1", n)
+	}
+}
@@ -0,0 +1,82 @@
+package cb_cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+)
+
+// ErrNoGetter is returned for keys no routed getter matches, without a default getter
+var ErrNoGetter = errors.New("[cb-cache] no getter for key")
+
+type getterRoute struct {
+	prefix  string // or
+	pattern string // of path.Match
+	getter  GetterFunc
+}
+
+// getterRouter dispatches keys to the getter of their prefix or pattern
+type getterRouter struct {
+	prefixes []getterRoute // longest first
+	patterns []getterRoute // in order of registration
+	fallback GetterFunc    // the getter of WithGetter, nil if none
+}
+
+// WithPrefixGetter loads keys starting with prefix, e.g. "user:", by getter.
+// The longest matching prefix wins, then the first matching pattern of
+// WithPatternGetter, then the getter of WithGetter. Keys matching none fail
+// with ErrNoGetter.
+func WithPrefixGetter(prefix string, getter GetterFunc) GOption {
+	return func(g *Group) {
+		if getter == nil {
+			panic("nil getter")
+		}
+		r := g.getterRouter()
+		r.prefixes = append(r.prefixes, getterRoute{prefix: prefix, getter: getter})
+		sort.SliceStable(r.prefixes, func(i, j int) bool {
+			return len(r.prefixes[i].prefix) > len(r.prefixes[j].prefix)
+		})
+	}
+}
+
+// WithPatternGetter loads keys matching pattern, as of path.Match, by getter,
+// see WithPrefixGetter for the order of matching
+func WithPatternGetter(pattern string, getter GetterFunc) GOption {
+	return func(g *Group) {
+		if getter == nil {
+			panic("nil getter")
+		}
+		if _, err := path.Match(pattern, ""); err != nil {
+			panic(fmt.Sprintf("illegal getter pattern %q: %v", pattern, err))
+		}
+		r := g.getterRouter()
+		r.patterns = append(r.patterns, getterRoute{pattern: pattern, getter: getter})
+	}
+}
+
+func (g *Group) getterRouter() *getterRouter {
+	if g.router == nil {
+		g.router = &getterRouter{}
+	}
+	return g.router
+}
+
+func (r *getterRouter) get(ctx context.Context, k string) ([]byte, error) {
+	for _, route := range r.prefixes {
+		if strings.HasPrefix(k, route.prefix) {
+			return route.getter(ctx, k)
+		}
+	}
+	for _, route := range r.patterns {
+		if ok, _ := path.Match(route.pattern, k); ok {
+			return route.getter(ctx, k)
+		}
+	}
+	if r.fallback != nil {
+		return r.fallback(ctx, k)
+	}
+	return nil, fmt.Errorf("%w %q", ErrNoGetter, k)
+}
@@ -0,0 +1,50 @@
+package cb_cache
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func routeGetter(name string) GetterFunc {
+	return func(ctx context.Context, k string) ([]byte, error) {
+		return []byte(name), nil
+	}
+}
+
+func TestGroup_RoutedGetters(t *testing.T) {
+	g := NewGroup("routed-getters", 1<<10,
+		WithPrefixGetter("user:", routeGetter("users")),
+		WithPrefixGetter("user:admin:", routeGetter("admins")),
+		WithPatternGetter("org:[0-9]*", routeGetter("orgs")),
+		WithPatternGetter("*:v2", routeGetter("v2")),
+		WithGetter(routeGetter("default")),
+	)
+
+	testCases := map[string]string{
+		"user:123":       "users",
+		"user:admin:1":   "admins",
+		"org:9":          "orgs",
+		"org:acme":       "default",
+		"team:v2":        "v2",
+		"user:1:v2":      "users",
+		"something-else": "default",
+	}
+	for k, want := range testCases {
+		if v, err := g.Get(context.Background(), k); err != nil || v.String() != want {
+			t.Errorf("Get(%s) = %q %v, want %q", k, v, err, want)
+		}
+	}
+}
+
+func TestGroup_RoutedGettersUnmatched(t *testing.T) {
+	g := NewGroup("routed-getters-unmatched", 1<<10,
+		WithPrefixGetter("user:", routeGetter("users")),
+	)
+	if v, err := g.Get(context.Background(), "user:1"); err != nil || v.String() != "users" {
+		t.Errorf("Get(user:1) = %q %v, want users", v, err)
+	}
+	if _, err := g.Get(context.Background(), "org:9"); !errors.Is(err, ErrNoGetter) {
+		t.Errorf("Get(org:9) error = %v, want ErrNoGetter", err)
+	}
+}
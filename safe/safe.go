@@ -1,13 +1,50 @@
 package safe
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime/debug"
 	"sync"
+	"time"
 )
 
+// ErrGoexit is returned to the other callers if fn called runtime.Goexit
+var ErrGoexit = errors.New("[cb-cache] runtime.Goexit was called")
+
+// PanicError is returned to all callers if fn panicked
+type PanicError struct {
+	Value any    // passed to panic
+	Stack []byte // of the panicking goroutine
+}
+
+func (p *PanicError) Error() string {
+	return fmt.Sprintf("[cb-cache] panic: %v\n\n%s", p.Value, p.Stack)
+}
+
+// Unwrap returns the error passed to panic, if it was one
+func (p *PanicError) Unwrap() error {
+	err, _ := p.Value.(error)
+	return err
+}
+
 type call struct {
-	wg  sync.WaitGroup // avoid reentrancy
-	val any
-	err error
+	done chan struct{} // closed when fn returned, avoid reentrancy
+	val  any
+	err  error
+
+	dups    int             // callers joined the first one
+	shared  bool            // dups > 0 when fn returned
+	chans   []chan<- Result // of DoChan callers
+	waiters int             // callers still waiting, a call of DoContext is cancelled at 0
+	ctx     *sharedContext  // nil if the call is not of DoContext
+}
+
+// Result is the result of a call, Shared tells whether it was given to several callers
+type Result struct {
+	Val    any
+	Err    error
+	Shared bool
 }
 
 type Group struct {
@@ -17,28 +54,227 @@ type Group struct {
 
 // Once is able to make fn just called once
 func (g *Group) Once(key string, fn func() (any, error)) (any, error) {
+	v, err, _ := g.Do(key, fn)
+	return v, err
+}
+
+// Do is like Once, shared tells whether v was given to several callers
+func (g *Group) Do(key string, fn func() (any, error)) (v any, err error, shared bool) {
 	g.mu.Lock()
 	if g.m == nil {
 		g.m = make(map[string]*call)
 	}
 
 	if c, ok := g.m[key]; ok {
+		c.join(nil)
 		g.mu.Unlock()
-		c.wg.Wait()
-		return c.val, c.err
+		<-c.done
+		return c.val, c.err, true
 	}
 
-	c := new(call)
-	c.wg.Add(1)
-	g.m[key] = c
+	c := g.newCall(key, nil)
+	g.mu.Unlock()
+
+	g.doCall(c, key, fn)
+	return c.val, c.err, c.shared
+}
+
+// DoChan is like Do but returns a channel receiving the result when it's ready,
+// fn is called in a new goroutine
+func (g *Group) DoChan(key string, fn func() (any, error)) <-chan Result {
+	ch := make(chan Result, 1)
+
+	g.mu.Lock()
+	if g.m == nil {
+		g.m = make(map[string]*call)
+	}
+
+	if c, ok := g.m[key]; ok {
+		c.join(nil)
+		c.chans = append(c.chans, ch)
+		g.mu.Unlock()
+		return ch
+	}
+
+	c := g.newCall(key, nil)
+	c.chans = append(c.chans, ch)
+	g.mu.Unlock()
+
+	go g.doCall(c, key, fn)
+	return ch
+}
+
+// DoContext is like Do but every caller stops waiting when its ctx is done,
+// returning ctx.Err(). fn is called in a new goroutine with a context carrying
+// the values of the first caller and the latest deadline of all callers,
+// it is cancelled only when all callers have gone.
+func (g *Group) DoContext(ctx context.Context, key string, fn func(ctx context.Context) (any, error)) (v any, err error, shared bool) {
+	if err := ctx.Err(); err != nil {
+		return nil, err, false
+	}
+
+	g.mu.Lock()
+	if g.m == nil {
+		g.m = make(map[string]*call)
+	}
+
+	c, ok := g.m[key]
+	if ok {
+		c.join(ctx)
+	} else {
+		c = g.newCall(key, newSharedContext(ctx))
+		sctx := c.ctx
+		go g.doCall(c, key, func() (any, error) {
+			return fn(sctx)
+		})
+	}
 	g.mu.Unlock()
 
-	c.val, c.err = fn()
-	c.wg.Done()
+	select {
+	case <-c.done:
+		return c.val, c.err, c.shared
+	case <-ctx.Done():
+	}
+
+	g.mu.Lock()
+	c.waiters--
+	if c.waiters == 0 && c.ctx != nil {
+		c.ctx.cancel(ctx.Err())
+		if g.m[key] == c { /*don't let new callers join the cancelled call*/
+			delete(g.m, key)
+		}
+	}
+	g.mu.Unlock()
+	return nil, ctx.Err(), ok
+}
 
+// Forget makes the next call of key call fn instead of joining
+// the one in flight, which still returns to its callers
+func (g *Group) Forget(key string) {
 	g.mu.Lock()
 	delete(g.m, key)
 	g.mu.Unlock()
+}
+
+// newCall puts a new call of key into g.m, g.mu is held
+func (g *Group) newCall(key string, ctx *sharedContext) *call {
+	c := &call{done: make(chan struct{}), waiters: 1, ctx: ctx}
+	g.m[key] = c
+	return c
+}
+
+// join adds a caller to c waiting with ctx, or without giving up if nil.
+// g.mu is held.
+func (c *call) join(ctx context.Context) {
+	c.dups++
+	c.waiters++
+	if c.ctx != nil {
+		c.ctx.join(ctx)
+	}
+}
+
+// doCall calls fn and hands its result to the callers of c. A panic of fn is
+// returned to all of them as *PanicError, runtime.Goexit as ErrGoexit to the others.
+func (g *Group) doCall(c *call, key string, fn func() (any, error)) {
+	normalReturn, recovered := false, false
+
+	// also runs on runtime.Goexit, which can't be recovered
+	defer func() {
+		if !normalReturn && !recovered {
+			c.val, c.err = nil, ErrGoexit
+		}
+
+		g.mu.Lock()
+		if g.m[key] == c {
+			delete(g.m, key)
+		}
+		c.shared = c.dups > 0
+		for _, ch := range c.chans {
+			ch <- Result{Val: c.val, Err: c.err, Shared: c.shared}
+		}
+		g.mu.Unlock()
+
+		if c.ctx != nil {
+			c.ctx.cancel(context.Canceled)
+		}
+		close(c.done)
+	}()
+
+	func() {
+		defer func() {
+			if !normalReturn {
+				if r := recover(); r != nil {
+					c.val, c.err = nil, &PanicError{Value: r, Stack: debug.Stack()}
+				}
+			}
+		}()
+		c.val, c.err = fn()
+		normalReturn = true
+	}()
+	if !normalReturn {
+		recovered = true
+	}
+}
+
+// sharedContext is the context of a call of DoContext
+type sharedContext struct {
+	context.Context // values of the first caller
+
+	mu        sync.Mutex
+	deadline  time.Time
+	unbounded bool // a caller has no deadline
+	done      chan struct{}
+	err       error
+}
+
+func newSharedContext(ctx context.Context) *sharedContext {
+	c := &sharedContext{Context: context.WithoutCancel(ctx), done: make(chan struct{})}
+	c.join(ctx)
+	return c
+}
+
+// join extends the deadline to the one of ctx, nil has none
+func (c *sharedContext) join(ctx context.Context) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if ctx == nil {
+		c.unbounded = true
+		return
+	}
+	if d, ok := ctx.Deadline(); !ok {
+		c.unbounded = true
+	} else if d.After(c.deadline) {
+		c.deadline = d
+	}
+}
+
+func (c *sharedContext) cancel(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.err == nil {
+		c.err = err
+		close(c.done)
+	}
+}
+
+func (c *sharedContext) Deadline() (time.Time, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.unbounded {
+		return time.Time{}, false
+	}
+	return c.deadline, true
+}
+
+func (c *sharedContext) Done() <-chan struct{} {
+	return c.done
+}
 
-	return c.val, c.err
+func (c *sharedContext) Err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err
 }
@@ -1,8 +1,10 @@
 package safe
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"runtime"
 	"sync"
 	"sync/atomic"
 	"testing"
@@ -67,29 +69,304 @@ func TestDoDupSuppress(t *testing.T) {
 }
 
 // simulate extreme concurrency scenarios
-func TestMaxgVisit(t *testing.T) {
-	g := &Group{maxg: 10}
-	calls := 100000
-	flag := false
-	mu := &sync.RWMutex{}
-	wg := sync.WaitGroup{}
+func TestOnceConcurrent(t *testing.T) {
+	// there is no limit of the callers, all of them get a result
+	var g Group
+	const calls = 10000
+	var failed int32
+	var wg sync.WaitGroup
 	for i := 0; i < calls; i++ {
 		wg.Add(1)
-		i_ := i
+		go func(i int) {
+			defer wg.Done()
+			if _, err := g.Once("key", func() (any, error) {
+				return i, nil
+			}); err != nil {
+				atomic.AddInt32(&failed, 1)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if failed != 0 {
+		t.Errorf("%d calls failed", failed)
+	}
+	if len(g.m) != 0 {
+		t.Errorf("%d calls leaked", len(g.m))
+	}
+}
+
+func TestDoShared(t *testing.T) {
+	var g Group
+	c := make(chan string)
+	results := make(chan bool, 2)
+	for i := 0; i < 2; i++ {
 		go func() {
-			ans, err := g.Once("key", func() (any, error) {
-				return i_, nil
+			_, _, shared := g.Do("key", func() (any, error) {
+				return <-c, nil
 			})
-			mu.Lock()
-			flag = flag || err != nil
-			mu.Unlock()
-			fmt.Println(ans)
+			results <- shared
 		}()
-		wg.Done()
 	}
+	time.Sleep(50 * time.Millisecond)
+	c <- "bar"
+	for i := 0; i < 2; i++ {
+		if !<-results {
+			t.Error("result is not shared")
+		}
+	}
+
+	if _, _, shared := g.Do("key", func() (any, error) { return "bar", nil }); shared {
+		t.Error("result of a single caller is shared")
+	}
+}
+
+func TestDoChan(t *testing.T) {
+	var g Group
+	c := make(chan string)
+	var calls int32
+	fn := func() (any, error) {
+		atomic.AddInt32(&calls, 1)
+		return <-c, nil
+	}
+
+	chans := []<-chan Result{g.DoChan("key", fn), g.DoChan("key", fn)}
+	c <- "bar"
+	for _, ch := range chans {
+		res := <-ch
+		if res.Val != "bar" || res.Err != nil || !res.Shared {
+			t.Errorf("DoChan = %+v, want shared bar", res)
+		}
+	}
+	if got := atomic.LoadInt32(&calls); got != 1 {
+		t.Errorf("number of calls = %d; want 1", got)
+	}
+}
+
+func TestForget(t *testing.T) {
+	var g Group
+	c := make(chan string)
+	first := g.DoChan("key", func() (any, error) {
+		return <-c, nil
+	})
+	time.Sleep(10 * time.Millisecond)
+
+	g.Forget("key")
+	v, _, _ := g.Do("key", func() (any, error) {
+		return "second", nil
+	})
+	if v != "second" {
+		t.Errorf("Do after Forget = %v, want second", v)
+	}
+
+	c <- "first"
+	if res := <-first; res.Val != "first" {
+		t.Errorf("forgotten call = %v, want first", res.Val)
+	}
+}
+
+func TestDoContextAbandon(t *testing.T) {
+	var g Group
+	c := make(chan string)
+	var cancelled atomic.Bool
+	fn := func(ctx context.Context) (any, error) {
+		select {
+		case v := <-c:
+			return v, nil
+		case <-ctx.Done():
+			cancelled.Store(true)
+			return nil, ctx.Err()
+		}
+	}
+
+	// the impatient caller gives up, the call goes on for the patient one
+	patient := make(chan Result, 1)
+	go func() {
+		v, err, shared := g.DoContext(context.Background(), "key", fn)
+		patient <- Result{Val: v, Err: err, Shared: shared}
+	}()
+	time.Sleep(10 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err, _ := g.DoContext(ctx, "key", fn); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("DoContext error = %v, want deadline exceeded", err)
+	}
+	if elapsed := time.Since(start); elapsed > 200*time.Millisecond {
+		t.Errorf("impatient caller waited %v", elapsed)
+	}
+
+	c <- "bar"
+	if res := <-patient; res.Val != "bar" || res.Err != nil || !res.Shared {
+		t.Errorf("DoContext = %+v, want shared bar", res)
+	}
+	if cancelled.Load() {
+		t.Error("call cancelled while a caller waits")
+	}
+}
+
+func TestDoContextCancel(t *testing.T) {
+	var g Group
+	errs := make(chan error, 1)
+	fn := func(ctx context.Context) (any, error) {
+		<-ctx.Done()
+		errs <- ctx.Err()
+		return nil, ctx.Err()
+	}
+
+	ctx1, cancel1 := context.WithCancel(context.Background())
+	ctx2, cancel2 := context.WithTimeout(context.Background(), time.Hour)
+	defer cancel2()
+	var wg sync.WaitGroup
+	for _, ctx := range []context.Context{ctx1, ctx2} {
+		wg.Add(1)
+		go func(ctx context.Context) {
+			defer wg.Done()
+			g.DoContext(ctx, "key", fn)
+		}(ctx)
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	cancel1()
+	select {
+	case err := <-errs:
+		t.Fatalf("call cancelled with %v while a caller waits", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	cancel2()
+	wg.Wait()
+	if err := <-errs; err != context.Canceled {
+		t.Errorf("call error = %v, want cancelled", err)
+	}
+
+	// the cancelled call is not joined
+	v, err, _ := g.DoContext(context.Background(), "key", func(ctx context.Context) (any, error) {
+		return "bar", nil
+	})
+	if v != "bar" || err != nil {
+		t.Errorf("DoContext = %v, %v, want bar", v, err)
+	}
+}
+
+func TestDoContextDeadline(t *testing.T) {
+	var g Group
+	deadlines := make(chan time.Time, 1)
+	release := make(chan struct{})
+	fn := func(ctx context.Context) (any, error) {
+		<-release
+		d, _ := ctx.Deadline()
+		deadlines <- d
+		return nil, nil
+	}
+
+	short, cancel1 := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel1()
+	long, cancel2 := context.WithTimeout(context.Background(), time.Hour)
+	defer cancel2()
+
+	var wg sync.WaitGroup
+	for _, ctx := range []context.Context{short, long} {
+		wg.Add(1)
+		go func(ctx context.Context) {
+			defer wg.Done()
+			g.DoContext(ctx, "key", fn)
+		}(ctx)
+		time.Sleep(10 * time.Millisecond)
+	}
+	close(release)
 	wg.Wait()
 
-	if !flag {
-		t.Error("should have error: server busy")
+	want, _ := long.Deadline()
+	if d := <-deadlines; !d.Equal(want) {
+		t.Errorf("deadline of the call = %v, want the latest %v", d, want)
+	}
+}
+
+func TestDoPanic(t *testing.T) {
+	var g Group
+	c := make(chan struct{})
+	fn := func() (any, error) {
+		<-c
+		panic("boom")
+	}
+
+	const n = 5
+	errs := make(chan error, n)
+	for i := 0; i < n; i++ {
+		go func() {
+			_, err := g.Once("key", fn)
+			errs <- err
+		}()
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(c)
+	for i := 0; i < n; i++ {
+		var perr *PanicError
+		if err := <-errs; !errors.As(err, &perr) || perr.Value != "boom" || len(perr.Stack) == 0 {
+			t.Errorf("Once error = %v, want the panic", err)
+		}
+	}
+
+	if len(g.m) != 0 {
+		t.Errorf("%d calls leaked after panic", len(g.m))
+	}
+	if v, err := g.Once("key", func() (any, error) { return "bar", nil }); v != "bar" || err != nil {
+		t.Errorf("Once after panic = %v, %v, want bar", v, err)
+	}
+}
+
+func TestDoPanicError(t *testing.T) {
+	var g Group
+	someErr := errors.New("some error")
+	res := <-g.DoChan("key", func() (any, error) {
+		panic(someErr)
+	})
+	if !errors.Is(res.Err, someErr) {
+		t.Errorf("DoChan error = %v, want the panicked error", res.Err)
+	}
+
+	_, err, _ := g.DoContext(context.Background(), "key", func(ctx context.Context) (any, error) {
+		panic("boom")
+	})
+	var perr *PanicError
+	if !errors.As(err, &perr) {
+		t.Errorf("DoContext error = %v, want the panic", err)
+	}
+	if len(g.m) != 0 {
+		t.Errorf("%d calls leaked after panic", len(g.m))
+	}
+}
+
+func TestDoGoexit(t *testing.T) {
+	var g Group
+	c := make(chan struct{})
+	joined := make(chan error, 1)
+
+	go func() {
+		g.Once("key", func() (any, error) {
+			<-c
+			runtime.Goexit()
+			return nil, nil
+		})
+		t.Error("Goexit did not exit the caller")
+	}()
+	time.Sleep(10 * time.Millisecond)
+	go func() {
+		_, err := g.Once("key", func() (any, error) { return "bar", nil })
+		joined <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+
+	close(c)
+	if err := <-joined; err != ErrGoexit {
+		t.Errorf("Once error = %v, want ErrGoexit", err)
+	}
+	g.mu.Lock()
+	leaked := len(g.m)
+	g.mu.Unlock()
+	if leaked != 0 {
+		t.Errorf("%d calls leaked after Goexit", leaked)
 	}
 }
@@ -0,0 +1,252 @@
+package cb_cache
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cold-bin/cb-cache/hashslot"
+	"github.com/cold-bin/cb-cache/registry"
+)
+
+const (
+	slotTableName = "slots" // name of the slot table in registry.Store
+
+	// redirectHeader carries "MOVED <slot> <node>" or "ASK <slot> <node>"
+	// of a http.StatusMisdirectedRequest response
+	redirectHeader = "X-Cb-Cache-Redirect"
+	// askingHeader marks a request redirected by ASK, the importing node serves it
+	askingHeader = "X-Cb-Cache-Asking"
+
+	redirectMoved = "MOVED"
+	redirectAsk   = "ASK"
+)
+
+// SetSlots switches the pool from the consistent hash ring to the fixed hash
+// slots of t, like Redis Cluster. Peers answer requests for slots they don't
+// own with MOVED and, while a slot is migrating, keys they don't have with ASK.
+func (c *HTTPPool) SetSlots(t *hashslot.Table) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.slots = t
+}
+
+// Slots returns a copy of the slot table, nil if the pool uses the ring
+func (c *HTTPPool) Slots() *hashslot.Table {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.slots == nil {
+		return nil
+	}
+	return c.slots.Clone()
+}
+
+// UseSlotStore loads the slot table from store and keeps it up to date. If the
+// store has no table yet, the slots are distributed over the current peers.
+// The etcd client of registry.New implements registry.Store.
+func (c *HTTPPool) UseSlotStore(ctx context.Context, store registry.Store) error {
+	watch := store.WatchValue(ctx, slotTableName)
+	bs, err := store.Load(ctx, slotTableName)
+	if err != nil {
+		return err
+	}
+
+	if bs == nil {
+		// nodes starting together all try, the table of the first is kept
+		t := hashslot.NewTable(hashslot.DefaultSlots)
+		t.Distribute(c.nodes()...)
+		if bs, err = json.Marshal(t); err != nil {
+			return err
+		}
+		if bs, err = store.Create(ctx, slotTableName, bs); err != nil {
+			return err
+		}
+	}
+	t, err := unmarshalSlots(bs)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	c.slots = t
+	c.slotStore = store
+	c.mu.Unlock()
+
+	go func() {
+		for bs := range watch {
+			t, err := unmarshalSlots(bs)
+			if err != nil {
+				log.Println("[cb-cache] bad slot table:", err)
+				continue
+			}
+			c.mu.Lock()
+			if c.slots == nil || t.Version >= c.slots.Version {
+				c.slots = t
+			}
+			c.mu.Unlock()
+		}
+	}()
+	return nil
+}
+
+// MigrateSlot starts moving slot to target. Keys the owner doesn't have are
+// redirected by ASK to target, which loads them, until the migration is completed.
+func (c *HTTPPool) MigrateSlot(ctx context.Context, slot int, target string) error {
+	target, _ = splitZone(target)
+	return c.updateSlots(ctx, func(t *hashslot.Table) error {
+		return t.Migrate(slot, target)
+	})
+}
+
+// CompleteSlotMigration makes the target of slot its owner, from now on
+// requests to the old owner are answered with MOVED.
+func (c *HTTPPool) CompleteSlotMigration(ctx context.Context, slot int) error {
+	return c.updateSlots(ctx, func(t *hashslot.Table) error {
+		return t.Complete(slot)
+	})
+}
+
+// updateSlots changes a copy of the table and publishes it to the slot store.
+// The stored table is changed by compare-and-swap, fn is applied again to the
+// table of a concurrent update, so admin operations on any node don't get lost.
+func (c *HTTPPool) updateSlots(ctx context.Context, fn func(t *hashslot.Table) error) error {
+	c.mu.Lock()
+	if c.slots == nil {
+		c.mu.Unlock()
+		return fmt.Errorf("[cb-cache] pool doesn't use hash slots")
+	}
+	store := c.slotStore
+	if store == nil {
+		defer c.mu.Unlock()
+		t := c.slots.Clone()
+		if err := fn(t); err != nil {
+			return err
+		}
+		c.slots = t
+		return nil
+	}
+	c.mu.Unlock()
+
+	for {
+		old, err := store.Load(ctx, slotTableName)
+		if err != nil {
+			return err
+		}
+		if old == nil {
+			return fmt.Errorf("[cb-cache] no slot table in the store")
+		}
+		t, err := unmarshalSlots(old)
+		if err != nil {
+			return err
+		}
+		if err = fn(t); err != nil {
+			return err
+		}
+		bs, err := json.Marshal(t)
+		if err != nil {
+			return err
+		}
+		swapped, err := store.CompareAndSwap(ctx, slotTableName, old, bs)
+		if err != nil {
+			return err
+		}
+		if swapped {
+			c.mu.Lock()
+			if c.slots == nil || t.Version >= c.slots.Version {
+				c.slots = t
+			}
+			c.mu.Unlock()
+			return nil
+		}
+		if err = ctx.Err(); err != nil {
+			return err
+		}
+	}
+}
+
+// pickSlotPeer must be called with c.mu held
+func (c *HTTPPool) pickSlotPeer(key string) (PeerGetter, bool) {
+	slot := c.slots.Slot(key)
+	if target, ok := c.slots.Target(slot); ok && target == c.self {
+		// importing the slot, keys are loaded here
+		return nil, false
+	}
+
+	owner := c.slots.Owner(slot)
+	if owner == "" || owner == c.self || !c.availableLocked(owner, time.Now()) {
+		return nil, false
+	}
+	return c.getterLocked(owner), true
+}
+
+// slotRedirect tells whether a peer request for key must be served elsewhere
+func (c *HTTPPool) slotRedirect(group *Group, key string, r *http.Request) (string, bool) {
+	c.mu.Lock()
+	t := c.slots
+	c.mu.Unlock()
+	if t == nil {
+		return "", false
+	}
+
+	slot := t.Slot(key)
+	owner := t.Owner(slot)
+	target, migrating := t.Target(slot)
+	switch {
+	case owner == "":
+		return "", false
+	case owner == c.self && migrating:
+		if _, ok := group.localCache(key); ok {
+			return "", false
+		}
+		return fmt.Sprintf("%s %d %s", redirectAsk, slot, target), true
+	case owner == c.self:
+		return "", false
+	case migrating && target == c.self && r.Header.Get(askingHeader) != "":
+		return "", false
+	default:
+		return fmt.Sprintf("%s %d %s", redirectMoved, slot, owner), true
+	}
+}
+
+// parseRedirect parses the value of redirectHeader
+func parseRedirect(s string) (kind string, slot int, node string, ok bool) {
+	fields := strings.Fields(s)
+	if len(fields) != 3 || (fields[0] != redirectMoved && fields[0] != redirectAsk) {
+		return
+	}
+	slot, err := strconv.Atoi(fields[1])
+	if err != nil {
+		return
+	}
+	return fields[0], slot, fields[2], true
+}
+
+// nodes returns all peers, including self, in a stable order
+func (c *HTTPPool) nodes() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	nodes := []string{c.self}
+	for addr := range c.httpGetters {
+		nodes = append(nodes, addr)
+	}
+	sort.Strings(nodes)
+	return nodes
+}
+
+func unmarshalSlots(bs []byte) (*hashslot.Table, error) {
+	t := &hashslot.Table{}
+	if err := json.Unmarshal(bs, t); err != nil {
+		return nil, err
+	}
+	if t.Len() == 0 {
+		return nil, fmt.Errorf("[cb-cache] empty slot table")
+	}
+	return t, nil
+}
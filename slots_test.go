@@ -0,0 +1,219 @@
+package cb_cache
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/cold-bin/cb-cache/hashslot"
+	"github.com/cold-bin/cb-cache/serialization/pb"
+)
+
+func TestHTTPPool_SlotRedirect(t *testing.T) {
+	g := NewGroup("slots", 1<<10, WithHotCacheBytes(1<<8),
+		WithGetter(func(ctx context.Context, k string) ([]byte, error) {
+			return []byte("origin " + k), nil
+		}))
+
+	a := NewHTTPPool("http://a", 50)
+	b := NewHTTPPool("http://b", 50)
+	table := hashslot.NewTable(16)
+	table.Distribute("http://a")
+	a.SetSlots(table)
+	b.SetSlots(table.Clone())
+
+	const key = "user:1"
+	slot := table.Slot(key)
+	serve := func(pool *HTTPPool, asking bool) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, DefaultBasePath+"slots/"+key, nil)
+		if asking {
+			r.Header.Set(askingHeader, "1")
+		}
+		w := httptest.NewRecorder()
+		pool.ServeHTTP(w, r)
+		return w
+	}
+	expect := func(w *httptest.ResponseRecorder, code int, redirect string) {
+		t.Helper()
+		if w.Code != code || w.Header().Get(redirectHeader) != redirect {
+			t.Errorf("got %d %q, want %d %q", w.Code, w.Header().Get(redirectHeader), code, redirect)
+		}
+	}
+
+	expect(serve(a, false), http.StatusOK, "")
+	expect(serve(b, false), http.StatusMisdirectedRequest, fmt.Sprintf("MOVED %d http://a", slot))
+
+	ctx := context.Background()
+	for _, pool := range []*HTTPPool{a, b} {
+		if err := pool.MigrateSlot(ctx, slot, "http://b"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// a still has the key cached, other keys of the slot go to b
+	expect(serve(a, false), http.StatusOK, "")
+	g.mainCache.cache.Clear()
+	expect(serve(a, false), http.StatusMisdirectedRequest, fmt.Sprintf("ASK %d http://b", slot))
+	expect(serve(b, false), http.StatusMisdirectedRequest, fmt.Sprintf("MOVED %d http://a", slot))
+	expect(serve(b, true), http.StatusOK, "")
+
+	for _, pool := range []*HTTPPool{a, b} {
+		if err := pool.CompleteSlotMigration(ctx, slot); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expect(serve(a, false), http.StatusMisdirectedRequest, fmt.Sprintf("MOVED %d http://b", slot))
+	expect(serve(b, false), http.StatusOK, "")
+}
+
+func TestHTTPPool_FollowRedirect(t *testing.T) {
+	owner := peerServer(t, http.StatusOK, "from owner")
+	stale := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(redirectHeader, "MOVED 0 "+owner.URL)
+		w.WriteHeader(http.StatusMisdirectedRequest)
+	}))
+	defer stale.Close()
+
+	pool := NewHTTPPool("http://self", 50)
+	table := hashslot.NewTable(hashslot.DefaultSlots)
+	table.Distribute(stale.URL)
+	pool.SetSlots(table)
+
+	peer, ok := pool.PickPeer("key")
+	if !ok {
+		t.Fatal("PickPeer found no peer")
+	}
+	res, err := peer.Get(context.Background(), &pb.Request{Group: "slots", Key: "key"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := string(res.GetValue()); v != "from owner" {
+		t.Errorf("Get = %s, want from owner", v)
+	}
+	pool.mu.Lock()
+	_, joined := pool.httpGetters[owner.URL]
+	pool.mu.Unlock()
+	if joined || len(pool.nodes()) != 1 {
+		t.Error("the target of the redirect has joined the peers")
+	}
+}
+
+// memStore is a registry.Store kept in memory
+type memStore struct {
+	mu     sync.Mutex
+	values map[string][]byte
+}
+
+func (s *memStore) Load(ctx context.Context, name string) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.values[name], nil
+}
+
+func (s *memStore) Save(ctx context.Context, name string, value []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.values[name] = value
+	return nil
+}
+
+func (s *memStore) Create(ctx context.Context, name string, value []byte) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if v, ok := s.values[name]; ok {
+		return v, nil
+	}
+	s.values[name] = value
+	return value, nil
+}
+
+func (s *memStore) CompareAndSwap(ctx context.Context, name string, old, value []byte) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if v, ok := s.values[name]; !ok || string(v) != string(old) {
+		return false, nil
+	}
+	s.values[name] = value
+	return true, nil
+}
+
+func (s *memStore) WatchValue(ctx context.Context, name string) <-chan []byte {
+	return make(chan []byte)
+}
+
+func TestHTTPPool_SlotStoreCreate(t *testing.T) {
+	store := &memStore{values: make(map[string][]byte)}
+
+	// nodes starting together see no table and disagree on the peers
+	pools := make([]*HTTPPool, 4)
+	var wg sync.WaitGroup
+	for i := range pools {
+		pools[i] = NewHTTPPool("http://node"+strconv.Itoa(i), 50)
+		defer pools[i].Close()
+		pools[i].Set("http://node"+strconv.Itoa(i), "http://other"+strconv.Itoa(i))
+		wg.Add(1)
+		go func(pool *HTTPPool) {
+			defer wg.Done()
+			if err := pool.UseSlotStore(context.Background(), store); err != nil {
+				t.Error(err)
+			}
+		}(pools[i])
+	}
+	wg.Wait()
+
+	want, _ := json.Marshal(pools[0].Slots())
+	for i, pool := range pools[1:] {
+		if got, _ := json.Marshal(pool.Slots()); string(got) != string(want) {
+			t.Errorf("node %d uses another slot table", i+1)
+		}
+	}
+	if stored, _ := store.Load(context.Background(), slotTableName); string(stored) != string(want) {
+		t.Error("the nodes don't use the stored slot table")
+	}
+}
+
+func TestHTTPPool_SlotStoreConcurrentUpdates(t *testing.T) {
+	store := &memStore{values: make(map[string][]byte)}
+	pools := make([]*HTTPPool, 2)
+	for i := range pools {
+		pools[i] = NewHTTPPool("http://node"+strconv.Itoa(i), 50)
+		defer pools[i].Close()
+		pools[i].Set("http://node0", "http://node1")
+		if err := pools[i].UseSlotStore(context.Background(), store); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// both nodes migrate slots at once, none of the migrations gets lost
+	const n = 20
+	version := pools[0].Slots().Version
+	var wg sync.WaitGroup
+	for slot := 0; slot < n; slot++ {
+		wg.Add(1)
+		go func(slot int) {
+			defer wg.Done()
+			if err := pools[slot%2].MigrateSlot(context.Background(), slot, "http://node2"); err != nil {
+				t.Error(err)
+			}
+		}(slot)
+	}
+	wg.Wait()
+
+	bs, _ := store.Load(context.Background(), slotTableName)
+	table, err := unmarshalSlots(bs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for slot := 0; slot < n; slot++ {
+		if target, ok := table.Target(slot); !ok || target != "http://node2" {
+			t.Errorf("slot %d migrates to %q, want node2", slot, target)
+		}
+	}
+	if table.Version != version+n {
+		t.Errorf("table version %d, want %d", table.Version, version+n)
+	}
+}
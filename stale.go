@@ -0,0 +1,65 @@
+package cb_cache
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// WithExpiration expires cached values ttl after they are loaded
+func WithExpiration(ttl time.Duration) GOption {
+	return func(g *Group) {
+		if ttl <= 0 {
+			panic("expiration must be greater than 0")
+		}
+		g.ttl = ttl
+	}
+}
+
+// WithStaleWhileRevalidate serves values up to d after they expired, flagged
+// as stale, while one refresh per key runs in the background
+func WithStaleWhileRevalidate(d time.Duration) GOption {
+	return func(g *Group) {
+		if d <= 0 {
+			panic("stale-while-revalidate must be greater than 0")
+		}
+		g.staleWhileRevalidate = d
+	}
+}
+
+// WithStaleIfError serves values up to d after they expired, flagged as stale,
+// if loading them again fails. Keys not found anymore are not served.
+func WithStaleIfError(d time.Duration) GOption {
+	return func(g *Group) {
+		if d <= 0 {
+			panic("stale-if-error must be greater than 0")
+		}
+		g.staleIfError = d
+	}
+}
+
+// revalidate refreshes k in the background, joined with other loads of k
+// by the loader. The request values of ctx are kept, but not its deadline.
+func (g *Group) revalidate(ctx context.Context, k string) {
+	if _, busy := g.revalidating.LoadOrStore(k, struct{}{}); busy {
+		return
+	}
+
+	ctx = context.WithoutCancel(ctx)
+	go func() {
+		defer g.revalidating.Delete(k)
+		g.loader.Once(k, func() (any, error) {
+			return g.load(ctx, k)
+		})
+	}()
+}
+
+// serveStale tells whether the cached value may be served after loading it
+// again failed, either because it was recomputed early or it's stale-if-error
+func (g *Group) serveStale(value ByteView, err error) bool {
+	if errors.Is(err, ErrNotFound) || errors.Is(err, ErrKeyEmpty) {
+		return false
+	}
+	now := time.Now()
+	return !value.expired(now) || now.Before(value.e.Add(g.staleIfError))
+}
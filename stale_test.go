@@ -0,0 +1,133 @@
+package cb_cache
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/cold-bin/cb-cache/serialization"
+	"github.com/cold-bin/cb-cache/serialization/pb"
+)
+
+func TestGroup_StaleWhileRevalidate(t *testing.T) {
+	var loads int32
+	release := make(chan struct{})
+	g := NewGroup("stale-while-revalidate", 1<<10,
+		WithExpiration(20*time.Millisecond),
+		WithStaleWhileRevalidate(time.Minute),
+		WithGetter(func(ctx context.Context, k string) ([]byte, error) {
+			n := atomic.AddInt32(&loads, 1)
+			if n > 1 {
+				<-release
+			}
+			return []byte("v" + strconv.Itoa(int(n))), nil
+		}))
+
+	if v, err := g.Get(context.Background(), "k"); err != nil || v.String() != "v1" || v.Stale() {
+		t.Fatalf("Get = %v %v, want fresh v1", v, err)
+	}
+	time.Sleep(30 * time.Millisecond)
+
+	// readers don't wait for the refresh, which runs once
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if v, err := g.Get(context.Background(), "k"); err != nil || v.String() != "v1" || !v.Stale() {
+				t.Errorf("Get = %v %v, want stale v1", v, err)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := g.Stats.StaleServes; n != 10 {
+		t.Errorf("StaleServes = %d, want 10", n)
+	}
+
+	close(release)
+	deadline := time.Now().Add(time.Second)
+	for {
+		v, err := g.Get(context.Background(), "k")
+		if err == nil && v.String() == "v2" && !v.Stale() {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Get = %v %v, not refreshed", v, err)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if n := atomic.LoadInt32(&loads); n != 2 {
+		t.Errorf("getter called %d times, want 2", n)
+	}
+}
+
+func TestGroup_StaleIfError(t *testing.T) {
+	var down, gone atomic.Bool
+	g := NewGroup("stale-if-error", 1<<10,
+		WithExpiration(20*time.Millisecond),
+		WithStaleIfError(time.Minute),
+		WithGetter(func(ctx context.Context, k string) ([]byte, error) {
+			switch {
+			case gone.Load():
+				return nil, ErrNotFound
+			case down.Load():
+				return nil, errors.New("database down")
+			}
+			return []byte("v"), nil
+		}))
+
+	if _, err := g.Get(context.Background(), "k"); err != nil {
+		t.Fatal(err)
+	}
+	down.Store(true)
+	time.Sleep(30 * time.Millisecond)
+	if v, err := g.Get(context.Background(), "k"); err != nil || v.String() != "v" || !v.Stale() {
+		t.Fatalf("Get = %v %v, want stale v", v, err)
+	}
+	if n := g.Stats.StaleServes; n != 1 {
+		t.Errorf("StaleServes = %d, want 1", n)
+	}
+
+	// a deleted key is not served
+	gone.Store(true)
+	if _, err := g.Get(context.Background(), "k"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get error = %v, want not found", err)
+	}
+}
+
+func TestHTTPPool_ServeStale(t *testing.T) {
+	var down atomic.Bool
+	g := NewGroup("serve-stale", 1<<10,
+		WithExpiration(20*time.Millisecond),
+		WithStaleIfError(time.Minute),
+		WithGetter(func(ctx context.Context, k string) ([]byte, error) {
+			if down.Load() {
+				return nil, errors.New("database down")
+			}
+			return []byte("v"), nil
+		}))
+	pool := NewHTTPPool("http://self", 50)
+	defer pool.Close()
+
+	if _, err := g.Get(context.Background(), "k"); err != nil {
+		t.Fatal(err)
+	}
+	down.Store(true)
+	time.Sleep(30 * time.Millisecond)
+
+	w := httptest.NewRecorder()
+	pool.ServeHTTP(w, httptest.NewRequest(http.MethodGet, DefaultBasePath+"serve-stale/k", nil))
+	res := &pb.Response{}
+	if err := (&serialization.Protobuf{}).Unmarshal(w.Body.Bytes(), res); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusOK || string(res.GetValue()) != "v" || !res.GetStale() {
+		t.Errorf("served %d %q stale=%v, want stale v", w.Code, res.GetValue(), res.GetStale())
+	}
+}
@@ -2,9 +2,11 @@ package main
 
 import (
 	"context"
+	"errors"
 	"flag"
 	"fmt"
 	cbcache "github.com/cold-bin/cb-cache"
+	"github.com/cold-bin/cb-cache/registry"
 	"log"
 	"net/http"
 	"time"
@@ -36,13 +38,14 @@ func main() {
 	// 创建本地group
 	g := cbcache.NewGroup("scores", 2<<10,
 		cbcache.WithHotCacheBytes(2<<8),
+		cbcache.WithNegativeCache(10*time.Second, 2<<8),
 		cbcache.WithGetter(func(ctx context.Context, key string) ([]byte, error) {
 			log.Println("[SlowDB] search key", key)
 			time.Sleep(time.Second)
 			if v, ok := db[key]; ok {
 				return []byte(v), nil
 			}
-			return []byte{}, fmt.Errorf("%s does not exist", key)
+			return []byte{}, fmt.Errorf("%s does not exist: %w", key, cbcache.ErrNotFound)
 		}))
 
 	// 启动api服务器
@@ -53,6 +56,10 @@ func main() {
 			http.Handle(cbcache.DefaultBasePath+"api", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 				key := r.URL.Query().Get("key")
 				view, err := g.Get(r.Context(), key)
+				if errors.Is(err, cbcache.ErrNotFound) {
+					http.Error(w, err.Error(), http.StatusNotFound)
+					return
+				}
 				if err != nil {
 					http.Error(w, err.Error(), http.StatusInternalServerError)
 					return
@@ -73,10 +80,13 @@ func main() {
 	for _, v := range addrMap {
 		addrs = append(addrs, v)
 	}
-	err := pool.EtcdRegistry(context.Background(), []string{"localhost:2379"}...)
+	r, err := registry.New(context.Background(), "_cb-cache/", []string{"localhost:2379"})
 	if err != nil {
 		panic(err)
 	}
+	if err := pool.UseRegistry(context.Background(), r); err != nil {
+		panic(err)
+	}
 	pool.Set(addrs...)
 	//pool.SetETCDRegistry(context.Background(), "49.233.30.197:2379")
 	// 注册给group，这样group就可以从远程服务器获取缓存了
@@ -0,0 +1,110 @@
+package cb_cache
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/cold-bin/cb-cache/registry"
+	"github.com/cold-bin/cb-cache/serialization/pb"
+)
+
+// ZonedPeer labels a peer address with its availability zone, e.g.
+// "http://10.0.0.1:8001?zone=az-1". The result can be passed to HTTPPool.Set
+// and is what the pool publishes to the registry.
+func ZonedPeer(addr, zone string) string {
+	return registry.Node{Addr: addr, Zone: zone}.String()
+}
+
+// splitZone is the inverse of ZonedPeer
+func splitZone(peer string) (addr, zone string) {
+	n := registry.ParseNode(peer)
+	return n.Addr, n.Zone
+}
+
+// ZoneStats counts the requests sent to the peers of one zone.
+// should be operated atomically
+type ZoneStats struct {
+	Requests uint64
+	Failures uint64
+}
+
+// replicaGetter tries the replicas of a key in order, the in-zone ones first,
+// and only moves on to the next replica when the previous one failed.
+type replicaGetter []*httpGetter
+
+func (rg replicaGetter) Get(ctx context.Context, req *pb.Request) (_r *pb.Response, _err error) {
+	for _, g := range rg {
+		if _r, _err = g.Get(ctx, req); _err == nil {
+			return _r, nil
+		}
+		if ctx.Err() != nil || answered(_err) {
+			return nil, _err
+		}
+	}
+	return nil, _err
+}
+
+// owners returns the peers holding key: the first node clockwise on the ring and,
+// with replication, the following nodes spread over distinct zones where possible.
+// Ejected peers are skipped, so their keys fall back to the next nodes.
+func (c *HTTPPool) owners(key string, now time.Time) []string {
+	owners := make([]string, 0, c.replication)
+	zones := make(map[string]struct{}, c.replication)
+	var spare []string // same-zone nodes skipped in favor of zone diversity
+	c.peers.Walk(key, func(node string) bool {
+		if !c.availableLocked(node, now) {
+			return true
+		}
+		zone := c.zoneOf(node)
+		if _, dup := zones[zone]; dup && len(owners) > 0 {
+			spare = append(spare, node)
+			return true
+		}
+		zones[zone] = struct{}{}
+		owners = append(owners, node)
+		return len(owners) < c.replication
+	})
+	for i := 0; len(owners) < c.replication && i < len(spare); i++ {
+		owners = append(owners, spare[i])
+	}
+	return owners
+}
+
+func (c *HTTPPool) zoneOf(addr string) string {
+	if addr == c.self {
+		return c.zone
+	}
+	if g, ok := c.httpGetters[addr]; ok {
+		return g.zone
+	}
+	return ""
+}
+
+// zoneCounter must be called with c.mu held
+func (c *HTTPPool) zoneCounter(zone string) *ZoneStats {
+	if c.zoneStats == nil {
+		c.zoneStats = make(map[string]*ZoneStats)
+	}
+	s, ok := c.zoneStats[zone]
+	if !ok {
+		s = &ZoneStats{}
+		c.zoneStats[zone] = s
+	}
+	return s
+}
+
+// ZoneStats returns a snapshot of the per-zone request counters
+func (c *HTTPPool) ZoneStats() map[string]ZoneStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	stats := make(map[string]ZoneStats, len(c.zoneStats))
+	for zone, s := range c.zoneStats {
+		stats[zone] = ZoneStats{
+			Requests: atomic.LoadUint64(&s.Requests),
+			Failures: atomic.LoadUint64(&s.Failures),
+		}
+	}
+	return stats
+}